@@ -0,0 +1,137 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Analysis describes a document as determined by inspecting its source,
+// without compiling it.
+type Analysis struct {
+	// Packages lists the packages loaded with \usepackage or \RequirePackage,
+	// in the order they appear.
+	Packages []string
+	// RTL reports whether the document is set up for right-to-left scripts
+	// such as Arabic or Hebrew, by loading bidi or selecting an RTL language
+	// with polyglossia or babel. Such documents need xelatex or lualatex;
+	// pdflatex fails on them.
+	RTL bool
+	// ShellEscape reports whether the document runs shell commands with
+	// \write18, or with \ShellEscape from the shellesc package. These are
+	// ignored unless Options.ShellEscape is set, which usually leaves
+	// generated content, such as figures, missing from the output.
+	ShellEscape bool
+}
+
+// packageLine matches \usepackage and \RequirePackage, capturing the list of
+// package names.
+var packageLine = regexp.MustCompile(
+	`\\(?:usepackage|RequirePackage)\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}`)
+
+// languageLine matches polyglossia's language commands and babel's package
+// options, capturing the language names.
+var languageLine = regexp.MustCompile(
+	`\\(?:setmainlanguage|setdefaultlanguage|setotherlanguages?)\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}|` +
+		`\\(?:usepackage|RequirePackage)\s*\[([^\]]*)\]\s*\{babel\}`)
+
+// shellCommand matches commands that run shell commands.
+var shellCommand = regexp.MustCompile(`\\(?:write18|ShellEscape|DelayedShellEscape)\b`)
+
+// rtlLanguages are the right-to-left languages supported by polyglossia and
+// babel.
+var rtlLanguages = map[string]bool{
+	"arabic":  true,
+	"hebrew":  true,
+	"persian": true,
+	"farsi":   true,
+	"urdu":    true,
+	"syriac":  true,
+	"divehi":  true,
+	"yiddish": true,
+}
+
+// Analyze inspects the source of a document. It is a quick, best-effort
+// scan; it doesn't expand macros, so packages loaded indirectly, such as by
+// the document class, are not found.
+func Analyze(document string) Analysis {
+	var a Analysis
+	document = stripComments(document)
+	for _, m := range packageLine.FindAllStringSubmatch(document, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			// LaTeX ignores spaces in package names, so "shell esc" loads
+			// shellesc.
+			if name = removeSpace(name); name != "" {
+				a.Packages = append(a.Packages, name)
+			}
+		}
+	}
+	a.ShellEscape = shellCommand.MatchString(document)
+	a.RTL = contains(a.Packages, "bidi")
+	for _, m := range languageLine.FindAllStringSubmatch(document, -1) {
+		for _, name := range strings.Split(m[1]+","+m[2], ",") {
+			if rtlLanguages[removeSpace(name)] {
+				a.RTL = true
+			}
+		}
+	}
+	return a
+}
+
+// stripComments removes TeX comments, which run from an unescaped % to the
+// end of the line. Like TeX, it joins a line ending in a comment to the next
+// one, so that names split across lines are found.
+func stripComments(document string) string {
+	var b strings.Builder
+	var lines = strings.Split(document, "\n")
+	for i, line := range lines {
+		var comment bool
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+			} else if line[j] == '%' {
+				line, comment = line[:j], true
+				break
+			}
+		}
+		b.WriteString(line)
+		if !comment && i < len(lines)-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// removeSpace removes all whitespace from s.
+func removeSpace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// ErrPackageNotAllowed is returned, wrapped, when a document loads a package
+// forbidden by Options.AllowPackages or Options.DenyPackages.
+var ErrPackageNotAllowed = errors.New("package not allowed")
+
+// checkPackages rejects documents that load forbidden packages.
+func checkPackages(a Analysis, options Options) error {
+	for _, name := range a.Packages {
+		if contains(options.DenyPackages, name) ||
+			(options.AllowPackages != nil && !contains(options.AllowPackages, name)) {
+			return fmt.Errorf("gotex: %w: %s", ErrPackageNotAllowed, name)
+		}
+	}
+	return nil
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzePackages(t *testing.T) {
+	var a = Analyze(`\documentclass{article}
+\usepackage[utf8]{inputenc}
+\usepackage{graphicx, xcolor}
+% \usepackage{shellesc}
+\RequirePackage{catchfile} % 100\% loaded
+\usepackage{shell esc, tab
+  ularx}
+\usepackage{data%
+tool}
+\begin{document}
+\end{document}
+`)
+	var want = []string{"inputenc", "graphicx", "xcolor", "catchfile",
+		"shellesc", "tabularx", "datatool"}
+	if !reflect.DeepEqual(a.Packages, want) {
+		t.Error("Wrong packages", a.Packages)
+	}
+}
+
+func TestPackageLists(t *testing.T) {
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "%PDF-1.5" > gotex.pdf`)
+	var document = `\documentclass{article}
+\usepackage{shellesc}
+\begin{document}
+\end{document}
+`
+	var _, err = Render(document, Options{
+		Command:      command,
+		DenyPackages: []string{"shellesc", "catchfile"},
+	})
+	if !errors.Is(err, ErrPackageNotAllowed) {
+		t.Error("Denied package should be rejected, got", err)
+	}
+	// LaTeX ignores spaces and commented line breaks in package names.
+	for _, name := range []string{"shell esc", "shell%\nesc"} {
+		_, err = Render(strings.Replace(document, "shellesc", name, 1), Options{
+			Command:      command,
+			DenyPackages: []string{"shellesc"},
+		})
+		if !errors.Is(err, ErrPackageNotAllowed) {
+			t.Errorf("Denied package %q should be rejected, got %v", name, err)
+		}
+	}
+	_, err = Render(document, Options{
+		Command:       command,
+		AllowPackages: []string{"graphicx"},
+	})
+	if !errors.Is(err, ErrPackageNotAllowed) {
+		t.Error("Unlisted package should be rejected, got", err)
+	}
+	if runs := countRuns(t, state); runs != 0 {
+		t.Error("Rejected documents should not be compiled, ran", runs)
+	}
+
+	_, err = Render(document, Options{
+		Command:       command,
+		AllowPackages: []string{"graphicx", "shellesc"},
+		DenyPackages:  []string{"catchfile"},
+	})
+	if err != nil {
+		t.Error("Allowed package should compile:", err)
+	}
+}
+
+func TestAnalyzeRTL(t *testing.T) {
+	var tests = map[string]bool{
+		`\usepackage{bidi}`: true,
+		`\usepackage{polyglossia}\setmainlanguage{arabic}`: true,
+		`\setotherlanguages{english, hebrew}`:              true,
+		`\usepackage[english,persian]{babel}`:              true,
+		`\usepackage{polyglossia}\setmainlanguage{french}`: false,
+		`\usepackage[hebrew]{inputenc}`:                    false,
+		`% \usepackage{bidi}`:                              false,
+	}
+	for document, expected := range tests {
+		if rtl := Analyze(document).RTL; rtl != expected {
+			t.Errorf("RTL of %q is %v, expected %v", document, rtl, expected)
+		}
+	}
+}
+
+func TestRTLEngine(t *testing.T) {
+	var document = `\documentclass{article}
+\usepackage{polyglossia}
+\setmainlanguage{hebrew}
+\begin{document}
+\end{document}
+`
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var dir = filepath.Dir(command)
+	if err := os.Rename(command, filepath.Join(dir, "xelatex")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	var buf bytes.Buffer
+	if _, err := Render(document, Options{Logger: log.New(&buf, "", 0)}); err != nil {
+		t.Fatal("Should compile with xelatex:", err)
+	}
+	if !strings.Contains(buf.String(), "using xelatex") {
+		t.Error("Expected xelatex to be selected, got", buf.String())
+	}
+
+	// An explicit pdflatex is kept, with a warning.
+	var pdflatex = filepath.Join(dir, "pdflatex")
+	if err := os.Symlink(filepath.Join(dir, "xelatex"), pdflatex); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := Render(document, Options{Command: pdflatex, Logger: log.New(&buf, "", 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "need xelatex or lualatex") {
+		t.Error("Expected a warning, got", buf.String())
+	}
+}
+
+func TestAnalyzeShellEscape(t *testing.T) {
+	var tests = map[string]bool{
+		`\immediate\write18{gnuplot plot.gp}`: true,
+		`\ShellEscape{date > date.tex}`:       true,
+		`\write18x`:                           false,
+		`\write1{toc}`:                        false,
+		`% \write18{rm -rf /}`:                false,
+	}
+	for document, expected := range tests {
+		if a := Analyze(document); a.ShellEscape != expected {
+			t.Errorf("ShellEscape of %q is %v, expected %v", document, a.ShellEscape, expected)
+		}
+	}
+
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var document = "\\documentclass{article}\n\\begin{document}\n" +
+		"\\immediate\\write18{gnuplot plot.gp}\n\\end{document}\n"
+	var buf bytes.Buffer
+	if _, err := Render(document, Options{Command: command, Logger: log.New(&buf, "", 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "needs ShellEscape") {
+		t.Error("Expected a warning, got", buf.String())
+	}
+	buf.Reset()
+	_, err := Render(document, Options{Command: command, ShellEscape: true, Logger: log.New(&buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "needs ShellEscape") {
+		t.Error("Unexpected warning with ShellEscape:", buf.String())
+	}
+}
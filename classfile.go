@@ -0,0 +1,52 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// checkClassFiles rejects ClassFiles names that aren't plain .cls or .sty
+// file names, so that they can't be written outside the working directory.
+func checkClassFiles(options Options) error {
+	for name := range options.ClassFiles {
+		if name != path.Base(name) || strings.ContainsAny(name, `/\`) ||
+			name == ".cls" || name == ".sty" ||
+			(!strings.HasSuffix(name, ".cls") && !strings.HasSuffix(name, ".sty")) {
+			return fmt.Errorf("gotex: invalid class file name %q", name)
+		}
+	}
+	return nil
+}
+
+// writeClassFiles writes the ClassFiles into dir, where LaTeX finds them
+// before any installed ones.
+func writeClassFiles(dir string, options Options) error {
+	for name, content := range options.ClassFiles {
+		if err := ioutil.WriteFile(path.Join(dir, name), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classFilesKey returns a string identifying the names and contents of the
+// ClassFiles, for keying precompiled formats.
+func classFilesKey(options Options) string {
+	var names = make([]string, 0, len(options.ClassFiles))
+	for name := range options.ClassFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&key, "%s\x00%d\x00%s", name, len(options.ClassFiles[name]),
+			options.ClassFiles[name])
+	}
+	return key.String()
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestClassFiles(t *testing.T) {
+	var command, state = fakeLatex(t, `cp corp.cls $STATE/corp.cls
+echo "%PDF-1.5" > gotex.pdf`)
+	var _, err = Render(`\documentclass{corp}`, Options{
+		Command:    command,
+		ClassFiles: map[string][]byte{"corp.cls": []byte("% corp")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(state, "corp.cls"))
+	if err != nil || string(content) != "% corp" {
+		t.Errorf("Class file wasn't written, got %q, %v", content, err)
+	}
+
+	for _, name := range []string{"../corp.cls", "sub/corp.sty", "corp.tex", ".cls", ""} {
+		_, err = Render(`\documentclass{corp}`, Options{
+			Command:    command,
+			ClassFiles: map[string][]byte{name: nil},
+		})
+		if err == nil {
+			t.Errorf("Should reject class file name %q", name)
+		}
+	}
+
+	requireLatex(t, "pdflatex")
+	var class = `\NeedsTeXFormat{LaTeX2e}
+\ProvidesClass{corp}
+\LoadClass{article}
+\newcommand{\corpname}{ACME}
+`
+	pdf, err := Render(`\documentclass{corp}
+\begin{document}
+\corpname
+\end{document}`, Options{ClassFiles: map[string][]byte{"corp.cls": []byte(class)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdf) == 0 {
+		t.Error("Expected a PDF")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FromEnv fills in options from environment variables, which is convenient
+// when gotex is configured by a deployment rather than by code. Only fields
+// left at their zero value are taken from the environment, so options set
+// explicitly always win. The variables are:
+//
+//	GOTEX_ENGINE     sets Command
+//	GOTEX_TEXINPUTS  sets Texinputs
+//	GOTEX_RUNS       sets Runs
+//
+// An error is returned if GOTEX_RUNS is not a non-negative integer.
+func FromEnv(options Options) (Options, error) {
+	if options.Command == "" {
+		options.Command = os.Getenv("GOTEX_ENGINE")
+	}
+	if options.Texinputs == "" {
+		options.Texinputs = os.Getenv("GOTEX_TEXINPUTS")
+	}
+	if v := os.Getenv("GOTEX_RUNS"); v != "" && options.Runs == 0 {
+		var runs, err = strconv.Atoi(v)
+		if err != nil || runs < 0 {
+			return options, fmt.Errorf("gotex: invalid GOTEX_RUNS %q", v)
+		}
+		options.Runs = runs
+	}
+	return options, nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("GOTEX_ENGINE", "/opt/texlive/bin/xelatex")
+	t.Setenv("GOTEX_TEXINPUTS", "/assets")
+	t.Setenv("GOTEX_RUNS", "2")
+
+	var options, err = FromEnv(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Command != "/opt/texlive/bin/xelatex" ||
+		options.Texinputs != "/assets" || options.Runs != 2 {
+		t.Errorf("Wrong options from env: %+v", options)
+	}
+
+	// Explicit options take precedence over the environment.
+	options, err = FromEnv(Options{Command: "pdflatex", Runs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Command != "pdflatex" ||
+		options.Texinputs != "/assets" || options.Runs != 1 {
+		t.Errorf("Explicit options should win: %+v", options)
+	}
+
+	t.Setenv("GOTEX_RUNS", "many")
+	if _, err = FromEnv(Options{}); err == nil {
+		t.Error("Should fail on invalid GOTEX_RUNS")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+)
+
+// ImageFormat is an output format for RenderEquation.
+type ImageFormat int
+
+const (
+	// PNG is a PNG image with a transparent background.
+	PNG ImageFormat = iota
+	// JPEG is a JPEG image.
+	JPEG
+	// SVG is an SVG image.
+	SVG
+	// PDF is a PDF cropped to the equation.
+	PDF
+)
+
+// equationResolution is the resolution, in DPI, of PNG and JPEG equations.
+const equationResolution = 300
+
+// RenderEquation renders a single LaTeX formula, such as `x^2+y^2=z^2`, to an
+// image cropped tightly around it, and writes it to outFilename. The formula
+// is typeset in display style with amsmath available, on a page sized to fit
+// by the standalone class with its preview option. Images other than PDF are
+// converted with Options.ImageConverter, which defaults to pdftocairo from
+// Poppler. PNG and JPEG images are rendered at 300 DPI.
+func RenderEquation(latex string, format ImageFormat, outFilename string, options Options) (err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	var document = `\documentclass[preview,border=1pt]{standalone}
+\usepackage{amsmath}
+\begin{document}
+$\displaystyle ` + latex + `$
+\end{document}
+`
+	dir, err := compile(document, options, nil)
+	if err != nil {
+		return err
+	}
+	// Clean up the temp directory. Conversion errors carry the converter's
+	// output, so the directory isn't needed for postmortem.
+	defer removeDir(dir, options)
+	image, err := convertPDF(dir, format, options)
+	if err != nil {
+		return err
+	}
+	return moveFile(image, outFilename, options.Durable)
+}
+
+// convertPDF converts the PDF in dir to the given format and returns the path
+// to the converted file.
+func convertPDF(dir string, format ImageFormat, options Options) (string, error) {
+	var converter = options.ImageConverter
+	if converter == "" {
+		converter = "pdftocairo"
+	}
+	var dpi = strconv.Itoa(equationResolution)
+	var args []string
+	var output string
+	switch format {
+	case PDF:
+		return path.Join(dir, "gotex.pdf"), nil
+	case PNG:
+		// pdftocairo adds the extension itself.
+		args = []string{"-png", "-transp", "-singlefile", "-r", dpi, "gotex.pdf", "gotex"}
+		output = "gotex.png"
+	case JPEG:
+		args = []string{"-jpeg", "-singlefile", "-r", dpi, "gotex.pdf", "gotex"}
+		output = "gotex.jpg"
+	case SVG:
+		args = []string{"-svg", "gotex.pdf", "gotex.svg"}
+		output = "gotex.svg"
+	default:
+		return "", fmt.Errorf("gotex: unknown image format %d", format)
+	}
+	var cmd = exec.Command(converter, args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("gotex: converting to image with %s failed: %v\n%s",
+			converter, err, out)
+	}
+	return path.Join(dir, output), nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderEquationConversion(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	var converter = filepath.Join(state, "pdftocairo")
+	var err = ioutil.WriteFile(converter, []byte(`#!/bin/sh
+echo "$*" > `+state+`/args
+for last; do :; done
+echo image > "$last.png"
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out = filepath.Join(t.TempDir(), "eq.png")
+	err = RenderEquation(`x^2+y^2=z^2`, PNG, out, Options{
+		Command:        command,
+		ImageConverter: converter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+	if !strings.Contains(string(fed), `{standalone}`) ||
+		!strings.Contains(string(fed), `$\displaystyle x^2+y^2=z^2$`) {
+		t.Errorf("Wrong equation document:\n%s", fed)
+	}
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if string(args) != "-png -transp -singlefile -r 300 gotex.pdf gotex\n" {
+		t.Errorf("Wrong converter arguments: %s", args)
+	}
+	if image, _ := ioutil.ReadFile(out); string(image) != "image\n" {
+		t.Errorf("Wrong image written: %q", image)
+	}
+}
+
+func TestRenderEquationCleanup(t *testing.T) {
+	var command, state = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var options = Options{
+		Command:        command,
+		ImageConverter: filepath.Join(state, "missing"),
+		TempDir:        tmp,
+	}
+	// Conversion fails.
+	var err = RenderEquation(`x`, PNG, filepath.Join(t.TempDir(), "eq.png"), options)
+	if err == nil {
+		t.Error("Conversion should fail")
+	}
+	// Moving the image fails.
+	err = RenderEquation(`x`, PDF, filepath.Join(state, "missing", "eq.pdf"), options)
+	if err == nil {
+		t.Error("Moving the image should fail")
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directories should be removed on failure, found", len(files))
+	}
+}
+
+func TestRenderEquation(t *testing.T) {
+	requireLatex(t, "pdflatex")
+	requireLatex(t, "pdftocairo")
+	var out = filepath.Join(t.TempDir(), "eq.png")
+	if err := RenderEquation(`x^2+y^2=z^2`, PNG, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var data, err = ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A cropped equation is much wider than tall, unlike a page.
+	var size = img.Bounds().Size()
+	if size.X <= size.Y || size.Y > 200 {
+		t.Error("Equation is not cropped:", size)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrEngineNotFound is returned, wrapped, when the LaTeX executable given by
+// Options.Command can't be found. Check for it with errors.Is, e.g. to tell
+// users to install TeX Live or to set Command to the full path.
+var ErrEngineNotFound = errors.New("LaTeX engine not found")
+
+// ErrNotConverged is returned, wrapped, when Options.FailOnNonConvergence is
+// set and the document still needs rerunning after the maximum number of
+// runs.
+var ErrNotConverged = errors.New("document did not converge")
+
+// LogError is a single error found in the LaTeX log file.
+type LogError struct {
+	// File is the source file the error was reported in, if known. It is
+	// only known for errors printed in -file-line-error style, which LaTeX
+	// doesn't use by default, so it is usually empty.
+	File string
+	// Line is the line number the error was reported on, or 0 if unknown.
+	Line int
+	// Message is the error text, without the leading "! ".
+	Message string
+	// Hint explains the likely cause of a common error in plain language, or
+	// is empty if there is no hint for it.
+	Hint string
+}
+
+// MathModeError is a LogError about math mode, such as "Missing $ inserted.".
+// These usually come from characters like _ that only work in math mode, or
+// from unbalanced $ signs, and often appear when data is interpolated into a
+// template without escaping. Use errors.As on an *Error to find one.
+type MathModeError struct {
+	LogError
+}
+
+// Error implements the error interface.
+func (e *MathModeError) Error() string {
+	if e.Hint == "" {
+		return e.Message
+	}
+	return e.Message + " " + e.Hint
+}
+
+// Error is returned when LaTeX fails to compile the document. It carries the
+// errors parsed from the log file, which is left in place for postmortem.
+type Error struct {
+	// Log is the path to the LaTeX log file.
+	Log string
+	// Errors contains every error found in the log, in order.
+	Errors []LogError
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return "LaTeX error. Check " + e.Log
+}
+
+// As finds the first math mode error in the log for errors.As, when target
+// is a **MathModeError.
+func (e *Error) As(target interface{}) bool {
+	var mathErr, ok = target.(**MathModeError)
+	if !ok {
+		return false
+	}
+	for _, le := range e.Errors {
+		if mathModeErrors[le.Message] {
+			*mathErr = &MathModeError{le}
+			return true
+		}
+	}
+	return false
+}
+
+// missingFile matches the error for a missing file, such as "LaTeX Error:
+// File `foo.sty' not found.", capturing the file name.
+var missingFile = regexp.MustCompile("File [`']([^']+)' not found")
+
+// capacityExceeded matches the error for exceeding one of TeX's capacities,
+// such as "TeX capacity exceeded, sorry [main memory size=5000000].",
+// capturing the capacity.
+var capacityExceeded = regexp.MustCompile(`TeX capacity exceeded, sorry \[([a-z ]+)=`)
+
+// capacityParams maps TeX's names for its capacities to the Memory
+// parameters that raise them.
+var capacityParams = map[string]string{
+	"main memory size":     "extra_mem_top",
+	"pool size":            "pool_size",
+	"save size":            "save_size",
+	"input stack size":     "stack_size",
+	"buffer size":          "buf_size",
+	"parameter stack size": "param_size",
+	"semantic nest size":   "nest_size",
+	"text input levels":    "max_in_open",
+	"hash size":            "hash_extra",
+	"font memory":          "font_mem_size",
+}
+
+// Suggestions returns actionable advice for fixing the errors, such as which
+// package to install for a missing .sty file, in plain language suitable for
+// users who aren't TeX experts. It returns nil if there is no advice for any
+// of the errors.
+func (e *Error) Suggestions() []string {
+	var suggestions []string
+	var add = func(s string) {
+		if !contains(suggestions, s) {
+			suggestions = append(suggestions, s)
+		}
+	}
+	for _, le := range e.Errors {
+		if m := missingFile.FindStringSubmatch(le.Message); m != nil {
+			var name = m[1]
+			switch ext := path.Ext(name); ext {
+			case ".sty", ".cls":
+				var pkg = strings.TrimSuffix(name, ext)
+				add("Install the package that provides " + name + ", usually " + pkg +
+					" (tlmgr install " + pkg + "), or add it with Options.ClassFiles.")
+			default:
+				add("Check that " + name + " exists in Options.BaseDir or Options.Texinputs.")
+			}
+		} else if m := capacityExceeded.FindStringSubmatch(le.Message); m != nil {
+			if param, ok := capacityParams[m[1]]; ok {
+				add("Increase the " + m[1] + " by setting " + param + " in Options.Memory.")
+			} else {
+				add("TeX ran out of " + m[1] + ", which usually means a command " +
+					"recurses infinitely; check recently changed macros.")
+			}
+		} else if le.Message == "Undefined control sequence." {
+			add("Check the spelling of the command, or load the package that defines it.")
+		} else if mathModeErrors[le.Message] || le.Message == "Misplaced alignment tab character &." {
+			// These usually come from unescaped data in templates.
+			add(strings.TrimSpace(le.Hint + " Use EscapeLatex to escape data."))
+		} else if le.Hint != "" {
+			add(le.Hint)
+		}
+	}
+	return suggestions
+}
+
+// mathModeErrors are the messages of errors about math mode.
+var mathModeErrors = map[string]bool{
+	"Missing $ inserted.":                      true,
+	"Display math should end with $$.":         true,
+	"Extra }, or forgotten $.":                 true,
+	"Bad math environment delimiter.":          true,
+	"Missing $$ inserted.":                     true,
+	"Extra \\right.":                           true,
+	"Missing \\right. inserted.":               true,
+	"You can't use `\\eqno' in vertical mode.": true,
+}
+
+// hints maps error messages to explanations of their likely cause.
+var hints = map[string]string{
+	"Missing $ inserted.": "A character that only works in math mode, " +
+		"such as _ or ^, was used in text. If it comes from data, escape " +
+		`it as \_ or \^{}; otherwise check that every $ has a matching $.`,
+	"Display math should end with $$.": "A displayed formula started " +
+		`with $$ or \[ isn't closed the same way.`,
+	"Extra }, or forgotten $.": "The math delimiters and braces are " +
+		"unbalanced; look for a missing $ or an extra }.",
+	"Bad math environment delimiter.": `The math delimiters \( \) or ` +
+		`\[ \] are nested or unbalanced.`,
+	"Misplaced alignment tab character &.": "A & was used outside a " +
+		`table. If it comes from data, escape it as \&.`,
+}
+
+// CIFormat selects the annotation syntax produced by Error.AnnotateCI.
+type CIFormat int
+
+const (
+	// GitHubActions produces workflow commands like
+	// "::error file=doc.tex,line=42::message".
+	GitHubActions CIFormat = iota
+	// GitLabCI produces compiler-style "doc.tex:42: error: message" lines,
+	// which GitLab and most other CI log viewers highlight.
+	GitLabCI
+)
+
+// AnnotateCI formats the errors as CI annotations, one per line. If no errors
+// could be parsed from the log, a single annotation pointing at the log file
+// is produced instead.
+func (e *Error) AnnotateCI(format CIFormat) string {
+	var errs = e.Errors
+	if len(errs) == 0 {
+		errs = []LogError{{Message: e.Error()}}
+	}
+	var b strings.Builder
+	for _, le := range errs {
+		switch format {
+		case GitLabCI:
+			// Errors whose file is unknown aren't attributed to one, since
+			// they may come from an \input file as well as the document.
+			if le.File != "" {
+				b.WriteString(le.File + ":")
+				if le.Line > 0 {
+					b.WriteString(strconv.Itoa(le.Line) + ":")
+				}
+				b.WriteString(" ")
+			}
+			b.WriteString("error: " + le.Message + "\n")
+		default:
+			var props []string
+			if le.File != "" {
+				props = append(props, "file="+escapeGitHubProperty(le.File))
+			}
+			if le.Line > 0 {
+				props = append(props, "line="+strconv.Itoa(le.Line))
+			}
+			b.WriteString("::error")
+			if len(props) > 0 {
+				b.WriteString(" " + strings.Join(props, ","))
+			}
+			b.WriteString("::" + escapeGitHubData(le.Message) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// escapeGitHubData escapes the message part of a GitHub workflow command.
+func escapeGitHubData(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
+
+// escapeGitHubProperty escapes a property value of a GitHub workflow command.
+func escapeGitHubProperty(s string) string {
+	s = escapeGitHubData(s)
+	s = strings.Replace(s, ":", "%3A", -1)
+	return strings.Replace(s, ",", "%2C", -1)
+}
+
+// engineErrors are fragments of error messages that mean the document needs
+// a different engine, usually XeTeX or LuaTeX, rather than fixing.
+var engineErrors = []string{
+	"requires either XeTeX or LuaTeX",
+	"Package fontspec Error",
+	"Package unicode-math Error",
+	"Package inputenc Error: Unicode character",
+	"not set up for use with LaTeX",
+}
+
+// isEngineError reports whether errs indicate that the document can't be
+// compiled with the engine that was used.
+func isEngineError(errs []LogError) bool {
+	for _, e := range errs {
+		for _, fragment := range engineErrors {
+			if strings.Contains(e.Message, fragment) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileLineError matches errors printed in -file-line-error style, such as
+// "./chapter.tex:12: Undefined control sequence.".
+var fileLineError = regexp.MustCompile(`^(.+\.[a-z]+):(\d+): (.*)$`)
+
+// lineMarker matches the line LaTeX prints after an error to show where it
+// happened, such as "l.12 \foo".
+var lineMarker = regexp.MustCompile(`^l\.(\d+)`)
+
+// getErrorsFromLog parses the log file in dir and returns the errors in it.
+// If tail is positive, only the last tail bytes of the log are parsed.
+func getErrorsFromLog(dir string, tail int64) []LogError {
+	return parseLogFile(path.Join(dir, "gotex.log"), tail)
+}
+
+// parseLogFile parses the named log file and returns the errors in it.
+func parseLogFile(name string, tail int64) []LogError {
+	var file, err = openLog(name, tail)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return parseLogErrors(file)
+}
+
+// openLog opens a log file for parsing. If tail is positive and the file is
+// larger than that, only its last tail bytes are read, starting at the first
+// complete line.
+func openLog(name string, tail int64) (io.ReadCloser, error) {
+	var file, err = os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if tail <= 0 {
+		return file, nil
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size() <= tail {
+		return file, err
+	}
+	if _, err = file.Seek(-tail, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Skip the partial line we landed in.
+	var r = bufio.NewReader(file)
+	if _, err = r.ReadString('\n'); err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, file}, nil
+}
+
+// parseLogErrors extracts errors from LaTeX log output.
+func parseLogErrors(r io.Reader) []LogError {
+	var errs []LogError
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line = scanner.Text()
+		if strings.HasPrefix(line, "! ") {
+			var message = strings.TrimPrefix(line, "! ")
+			errs = append(errs, LogError{Message: message, Hint: hints[message]})
+		} else if m := fileLineError.FindStringSubmatch(line); m != nil {
+			var n, _ = strconv.Atoi(m[2])
+			errs = append(errs, LogError{File: m[1], Line: n, Message: m[3], Hint: hints[m[3]]})
+		} else if m := lineMarker.FindStringSubmatch(line); m != nil && len(errs) > 0 {
+			// Attach the line number to the error it belongs to.
+			if last := &errs[len(errs)-1]; last.Line == 0 {
+				last.Line, _ = strconv.Atoi(m[1])
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const multiErrorLog = `This is pdfTeX, Version 3.14159265-2.6-1.40.18 (TeX Live 2017)
+! Undefined control sequence.
+l.3 \foo
+        
+./chapter.tex:7: Missing $ inserted.
+! Extra }, or forgotten $.
+l.42 a}
+`
+
+func TestAnnotateCI(t *testing.T) {
+	var e = &Error{
+		Log:    "/tmp/gotex-1/gotex.log",
+		Errors: parseLogErrors(strings.NewReader(multiErrorLog)),
+	}
+	if len(e.Errors) != 3 {
+		t.Fatal("Expected 3 errors, got", len(e.Errors))
+	}
+
+	var github = "::error line=3::Undefined control sequence.\n" +
+		"::error file=./chapter.tex,line=7::Missing $ inserted.\n" +
+		"::error line=42::Extra }, or forgotten $.\n"
+	if got := e.AnnotateCI(GitHubActions); got != github {
+		t.Errorf("Wrong GitHub annotations:\n%s", got)
+	}
+
+	var gitlab = "error: Undefined control sequence.\n" +
+		"./chapter.tex:7: error: Missing $ inserted.\n" +
+		"error: Extra }, or forgotten $.\n"
+	if got := e.AnnotateCI(GitLabCI); got != gitlab {
+		t.Errorf("Wrong GitLab annotations:\n%s", got)
+	}
+
+	e.Errors = nil
+	if got := e.AnnotateCI(GitHubActions); got !=
+		"::error::LaTeX error. Check /tmp/gotex-1/gotex.log\n" {
+		t.Errorf("Wrong fallback annotation: %s", got)
+	}
+	if got := e.AnnotateCI(GitLabCI); got != "error: LaTeX error. Check /tmp/gotex-1/gotex.log\n" {
+		t.Errorf("Wrong GitLab fallback annotation: %s", got)
+	}
+}
+
+func TestEngineNotFound(t *testing.T) {
+	for _, command := range []string{
+		"gotex-no-such-latex",
+		filepath.Join(t.TempDir(), "pdflatex"),
+	} {
+		var _, err = Render("doc", Options{Command: command})
+		if !errors.Is(err, ErrEngineNotFound) {
+			t.Errorf("Missing %s should give ErrEngineNotFound, got %v", command, err)
+		}
+		// Probing the version doesn't hide it.
+		_, err = Render("doc", Options{Command: command, MinTeXLive: 2020})
+		if !errors.Is(err, ErrEngineNotFound) {
+			t.Errorf("Missing %s with MinTeXLive should give ErrEngineNotFound, got %v", command, err)
+		}
+	}
+
+	// Other failures are not reported as a missing engine.
+	var command, _ = fakeLatex(t, "exit 1")
+	var _, err = Render("doc", Options{Command: command})
+	if err == nil || errors.Is(err, ErrEngineNotFound) {
+		t.Error("Failing engine should give a LaTeX error, got", err)
+	}
+}
+
+// writeLargeLog writes a log of about 10 MB to dir with an error at the start
+// and another at the end.
+func writeLargeLog(t testing.TB, dir string) {
+	var log = "! Early error.\nl.1 x\n" +
+		strings.Repeat("Overfull \\hbox (1.0pt too wide) in paragraph\n", 200000) +
+		"! Late error.\nl.9000 y\n"
+	var err = ioutil.WriteFile(filepath.Join(dir, "gotex.log"), []byte(log), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	var dir = t.TempDir()
+	writeLargeLog(t, dir)
+
+	if errs := getErrorsFromLog(dir, 0); len(errs) != 2 {
+		t.Error("Whole log should have 2 errors, got", len(errs))
+	}
+	var errs = getErrorsFromLog(dir, 4096)
+	if len(errs) != 1 || errs[0].Message != "Late error." || errs[0].Line != 9000 {
+		t.Errorf("Tail should only have the late error: %+v", errs)
+	}
+}
+
+func BenchmarkLogWhole(b *testing.B) {
+	var dir = b.TempDir()
+	writeLargeLog(b, dir)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getErrorsFromLog(dir, 0)
+	}
+}
+
+func BenchmarkLogTail(b *testing.B) {
+	var dir = b.TempDir()
+	writeLargeLog(b, dir)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getErrorsFromLog(dir, 64<<10)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var dir = t.TempDir()
+	var pdflatex, state = fakeLatex(t, `echo run >> $STATE/runs
+case "$(cat)" in
+*fontspec*) echo "! Fatal Package fontspec Error: The fontspec package requires either XeTeX or LuaTeX." ;;
+*) echo "! Undefined control sequence." ;;
+esac > gotex.log
+exit 1`)
+	var xelatex = filepath.Join(dir, "xelatex")
+	var err = ioutil.WriteFile(xelatex,
+		[]byte("#!/bin/sh\necho run >> "+dir+"/runs\necho '%PDF-1.5' > gotex.pdf\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var options = Options{Command: pdflatex, Fallback: []string{xelatex}}
+
+	var pdf []byte
+	pdf, err = Render(`\usepackage{fontspec}`, options)
+	if err != nil {
+		t.Fatal("Should fall back to xelatex:", err)
+	}
+	if string(pdf) != "%PDF-1.5\n" {
+		t.Error("Wrong PDF", string(pdf))
+	}
+	if countRuns(t, state) != 1 || countRuns(t, dir) != 1 {
+		t.Error("Each engine should run once")
+	}
+
+	// Syntax errors don't trigger the fallback.
+	_, err = Render(`\error`, options)
+	if err == nil {
+		t.Fatal("Should fail")
+	}
+	if countRuns(t, state) != 2 || countRuns(t, dir) != 1 {
+		t.Error("Fallback should not run for syntax errors")
+	}
+}
+
+func TestCollectAllErrors(t *testing.T) {
+	// This engine stops at the first of three errors if asked to.
+	var command, _ = fakeLatex(t, `cat > /dev/null
+echo "! Undefined control sequence." > gotex.log
+case "$*" in *-halt-on-error*) exit 1 ;; esac
+echo "! Missing $ inserted." >> gotex.log
+echo "! Extra }, or forgotten $." >> gotex.log
+exit 1`)
+	var count = func(options Options) int {
+		var _, err = Render("doc", options)
+		var latexErr *Error
+		if !errors.As(err, &latexErr) {
+			t.Fatal("Expected a LaTeX error, got", err)
+		}
+		return len(latexErr.Errors)
+	}
+	if n := count(Options{Command: command}); n != 1 {
+		t.Error("Should halt on the first error, got", n)
+	}
+	if n := count(Options{Command: command, CollectAllErrors: true}); n != 3 {
+		t.Error("Should collect all errors, got", n)
+	}
+
+	requireLatex(t, "pdflatex")
+	var document = `\documentclass{article}
+\begin{document}
+\undefinedone \undefinedtwo
+\end{document}
+`
+	var _, err = Render(document, Options{})
+	var latexErr *Error
+	if !errors.As(err, &latexErr) || len(latexErr.Errors) != 1 {
+		t.Error("Should report one error", err)
+	}
+	_, err = Render(document, Options{CollectAllErrors: true})
+	if !errors.As(err, &latexErr) || len(latexErr.Errors) != 2 {
+		t.Error("Should report two errors", err)
+	}
+}
+
+func TestMathModeError(t *testing.T) {
+	var command, _ = fakeLatex(t, `cat > /dev/null
+printf '! Missing $ inserted.\n<inserted text> \n                $\nl.3 Order_\n' > gotex.log
+exit 1`)
+	var _, err = Render("doc", Options{Command: command})
+	var mathErr *MathModeError
+	if !errors.As(err, &mathErr) {
+		t.Fatal("Expected a MathModeError, got", err)
+	}
+	if mathErr.Line != 3 || !strings.Contains(mathErr.Hint, `\_`) {
+		t.Errorf("Wrong math mode error: %+v", mathErr)
+	}
+
+	// Other errors are not math mode errors.
+	command, _ = fakeLatex(t, `cat > /dev/null
+echo "! Undefined control sequence." > gotex.log
+exit 1`)
+	_, err = Render("doc", Options{Command: command})
+	if err == nil || errors.As(err, &mathErr) {
+		t.Error("Expected a plain LaTeX error, got", err)
+	}
+
+	// The math mode error is found among others, even when wrapped.
+	err = fmt.Errorf("gotex: template invoice: %w", &Error{Errors: []LogError{
+		{Message: "Undefined control sequence."},
+		{Message: "Extra }, or forgotten $."},
+	}})
+	if !errors.As(err, &mathErr) || mathErr.Message != "Extra }, or forgotten $." {
+		t.Error("Expected the second error to be found, got", mathErr)
+	}
+
+	requireLatex(t, "pdflatex")
+	_, err = Render(`\documentclass{article}
+\begin{document}
+Order number: ABC_123
+\end{document}
+`, Options{})
+	if !errors.As(err, &mathErr) {
+		t.Fatal("Expected a MathModeError, got", err)
+	}
+	if mathErr.Message != "Missing $ inserted." {
+		t.Error("Wrong error", mathErr.Message)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	var e = &Error{Errors: []LogError{
+		{Message: "LaTeX Error: File `siunitx.sty' not found."},
+		{Message: "LaTeX Error: File `siunitx.sty' not found."},
+		{Message: "LaTeX Error: File `logo.png' not found."},
+		{Message: "TeX capacity exceeded, sorry [main memory size=5000000]."},
+		{Message: "Missing $ inserted.", Hint: hints["Missing $ inserted."]},
+		{Message: "Misplaced alignment tab character &.", Hint: hints["Misplaced alignment tab character &."]},
+		{Message: "Extra \\right."},
+		{Message: "Paragraph ended before \\foo was complete."},
+		{Message: "Something else.", Hint: "Remember to escape the output."},
+	}}
+	var suggestions = e.Suggestions()
+	var want = []string{
+		"Install the package that provides siunitx.sty, usually siunitx " +
+			"(tlmgr install siunitx), or add it with Options.ClassFiles.",
+		"Check that logo.png exists in Options.BaseDir or Options.Texinputs.",
+		"Increase the main memory size by setting extra_mem_top in Options.Memory.",
+		hints["Missing $ inserted."] + " Use EscapeLatex to escape data.",
+		hints["Misplaced alignment tab character &."] + " Use EscapeLatex to escape data.",
+		"Use EscapeLatex to escape data.",
+		"Remember to escape the output.",
+	}
+	if len(suggestions) != len(want) {
+		t.Fatalf("Wrong suggestions:\n%s", strings.Join(suggestions, "\n"))
+	}
+	for i := range want {
+		if suggestions[i] != want[i] {
+			t.Errorf("Suggestion %d is %q, expected %q", i, suggestions[i], want[i])
+		}
+	}
+
+	var command, _ = fakeLatex(t, `cat > /dev/null
+echo "! LaTeX Error: File 'nosuchpackage.sty' not found." > gotex.log
+exit 1`)
+	var _, err = Render("doc", Options{Command: command})
+	var latexErr *Error
+	if !errors.As(err, &latexErr) {
+		t.Fatal("Expected a LaTeX error, got", err)
+	}
+	suggestions = latexErr.Suggestions()
+	if len(suggestions) != 1 || !strings.Contains(suggestions[0], "tlmgr install nosuchpackage") {
+		t.Errorf("Expected a tlmgr suggestion, got %q", suggestions)
+	}
+
+	requireLatex(t, "pdflatex")
+	_, err = Render(`\documentclass{article}
+\usepackage{nosuchpackage}
+\begin{document}
+\end{document}
+`, Options{})
+	if !errors.As(err, &latexErr) {
+		t.Fatal("Expected a LaTeX error, got", err)
+	}
+	suggestions = latexErr.Suggestions()
+	if len(suggestions) != 1 || !strings.Contains(suggestions[0], "tlmgr install nosuchpackage") {
+		t.Errorf("Expected a tlmgr suggestion, got %q", suggestions)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import "strings"
+
+// latexEscaper replaces the characters that are special to LaTeX in text.
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`$`, `\$`,
+	`&`, `\&`,
+	`#`, `\#`,
+	`%`, `\%`,
+	`_`, `\_`,
+	`^`, `\textasciicircum{}`,
+	`~`, `\textasciitilde{}`,
+	`<`, `\textless{}`,
+	`>`, `\textgreater{}`,
+)
+
+// EscapeLatex escapes the characters that are special to LaTeX in s, so that
+// it is typeset as plain text. Use it for data interpolated into a document,
+// such as with text/template; unescaped, a name like "Smith & Sons" or
+// "file_name" fails to compile.
+func EscapeLatex(s string) string {
+	return latexEscaper.Replace(s)
+}
+
+// RenderTable returns a tabular environment with the given column spec,
+// such as "lr" or "|l|r|", containing rows with each cell escaped with
+// EscapeLatex. Every row should have as many cells as spec has columns.
+func RenderTable(rows [][]string, spec string) string {
+	var b strings.Builder
+	b.WriteString(`\begin{tabular}{` + spec + "}\n")
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				b.WriteString(" & ")
+			}
+			b.WriteString(EscapeLatex(cell))
+		}
+		b.WriteString(` \\` + "\n")
+	}
+	b.WriteString(`\end{tabular}` + "\n")
+	return b.String()
+}
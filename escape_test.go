@@ -0,0 +1,46 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import "testing"
+
+func TestEscapeLatex(t *testing.T) {
+	var tests = map[string]string{
+		"Smith & Sons":  `Smith \& Sons`,
+		"100% of $5_a":  `100\% of \$5\_a`,
+		`C:\dir{x}`:     `C:\textbackslash{}dir\{x\}`,
+		"#1 ^ ~ < >":    `\#1 \textasciicircum{} \textasciitilde{} \textless{} \textgreater{}`,
+		"plain text":    "plain text",
+		"Ünïcödé stays": "Ünïcödé stays",
+	}
+	for in, expected := range tests {
+		if out := EscapeLatex(in); out != expected {
+			t.Errorf("EscapeLatex(%q) = %q, expected %q", in, out, expected)
+		}
+	}
+}
+
+func TestRenderTable(t *testing.T) {
+	var table = RenderTable([][]string{
+		{"Item", "Price"},
+		{"R&D_costs", "$100 (50%)"},
+	}, "lr")
+	var expected = `\begin{tabular}{lr}
+Item & Price \\
+R\&D\_costs & \$100 (50\%) \\
+\end{tabular}
+`
+	if table != expected {
+		t.Errorf("Wrong table:\n%s", table)
+	}
+
+	requireLatex(t, "pdflatex")
+	var _, err = Render(`\documentclass{article}
+\begin{document}
+`+table+`\end{document}
+`, Options{})
+	if err != nil {
+		t.Error("Table should compile:", err)
+	}
+}
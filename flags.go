@@ -0,0 +1,116 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrEngineTooOld is returned, wrapped, when the engine is older than
+// Options.MinTeXLive.
+var ErrEngineTooOld = errors.New("LaTeX engine too old")
+
+// probeCache holds the output of each engine run with each probing flag so
+// far, so that each probe only runs once per process.
+var probeCache = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// SupportsFlag reports whether the LaTeX engine command accepts flag, such
+// as "-synctex" or "-file-line-error". Any value after "=" is ignored. It
+// runs the engine with --help the first time and caches the answer, so it is
+// cheap to call repeatedly. Use it to avoid "unknown option" failures with
+// engines or versions that lack a flag.
+func SupportsFlag(command, flag string) (bool, error) {
+	var help, err = probeEngine(command, "--help")
+	if err != nil {
+		return false, err
+	}
+	var name = strings.TrimLeft(flag, "-")
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return false, nil
+	}
+	// Match the whole name, so that "-output" isn't mistaken for a prefix
+	// of "-output-directory".
+	var whole = regexp.MustCompile(`-` + regexp.QuoteMeta(name) + `(?:[=\s]|$)`)
+	return whole.MatchString(help), nil
+}
+
+// probeEngine returns the output of running command with the single
+// argument flag, such as --help or --version, running it if it hasn't been
+// run before.
+func probeEngine(command, flag string) (string, error) {
+	probeCache.Lock()
+	defer probeCache.Unlock()
+	var key = command + "\x00" + flag
+	if output, ok := probeCache.m[key]; ok {
+		return output, nil
+	}
+	var cmd = exec.Command(command, flag)
+	// Run somewhere harmless, in case the engine writes any files.
+	cmd.Dir = os.TempDir()
+	var output, err = cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+		return "", fmt.Errorf("gotex: %w: %v", ErrEngineNotFound, err)
+	}
+	if len(output) == 0 {
+		return "", fmt.Errorf("gotex: can't probe %s with %s: %v", command, flag, err)
+	}
+	probeCache.m[key] = string(output)
+	return string(output), nil
+}
+
+// texLiveYear matches the TeX Live release in an engine's version banner,
+// such as "pdfTeX 3.141592653-2.6-1.40.24 (TeX Live 2022/Debian)".
+var texLiveYear = regexp.MustCompile(`\(TeX Live (\d{4})`)
+
+// checkVersion fails if the engine is older than Options.MinTeXLive.
+func checkVersion(options Options) error {
+	if options.MinTeXLive == 0 {
+		return nil
+	}
+	var banner, err = probeEngine(options.Command, "--version")
+	if err != nil {
+		return err
+	}
+	var m = texLiveYear.FindStringSubmatch(banner)
+	if m == nil {
+		return fmt.Errorf("gotex: %w: document requires TeX Live >= %d, "+
+			"but the version of %s is unknown", ErrEngineTooOld, options.MinTeXLive, options.Command)
+	}
+	if year, _ := strconv.Atoi(m[1]); year < options.MinTeXLive {
+		return fmt.Errorf("gotex: %w: document requires TeX Live >= %d, "+
+			"but %s is from TeX Live %d", ErrEngineTooOld, options.MinTeXLive, options.Command, year)
+	}
+	return nil
+}
+
+// checkFlags turns off options whose flags the engine doesn't support,
+// logging a warning for each, so that LaTeX doesn't fail on them.
+func checkFlags(options *Options) {
+	if options.SyncTeX {
+		if ok, err := SupportsFlag(options.Command, "-synctex"); !ok {
+			logf(*options, "gotex: %s doesn't support -synctex, ignoring SyncTeX (%v)",
+				options.Command, err)
+			options.SyncTeX = false
+		}
+	}
+	if options.draft {
+		// Without draft mode, the document is still checked, just slower.
+		if ok, _ := SupportsFlag(options.Command, "-draftmode"); !ok {
+			options.draft = false
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSupportsFlag(t *testing.T) {
+	var command, state = fakeLatex(t, `echo probe >> $STATE/probes
+echo "Usage: pdftex [OPTION]... [TEXNAME[.tex]] [COMMANDS]"
+echo "[-no]-file-line-error   disable/enable file:line:error style messages"
+echo "-output-directory=DIR    use existing DIR as the directory to write files in"
+echo "-recorder               enable filename recorder"`)
+	var tests = map[string]bool{
+		"-file-line-error":  true,
+		"--recorder":        true,
+		"-output-directory": true,
+		"-output":           false,
+		"-record":           false,
+		"-synctex=1":        false,
+		"":                  false,
+	}
+	for flag, expected := range tests {
+		var ok, err = SupportsFlag(command, flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Errorf("SupportsFlag(%q) = %v, expected %v", flag, ok, expected)
+		}
+	}
+	var probes, _ = ioutil.ReadFile(filepath.Join(state, "probes"))
+	if n := strings.Count(string(probes), "probe"); n != 1 {
+		t.Error("Expected the engine to be probed once, got", n)
+	}
+
+	if _, err := SupportsFlag(filepath.Join(state, "missing"), "-recorder"); err == nil {
+		t.Error("Should fail for a missing engine")
+	}
+}
+
+func TestSyncTeXUnsupported(t *testing.T) {
+	var command, state = fakeLatex(t, `if [ "$1" = --help ]; then echo "-recorder"; exit; fi
+echo "$*" > $STATE/args
+echo "%PDF-1.5" > gotex.pdf`)
+	var buf bytes.Buffer
+	var _, err = Render("doc", Options{Command: command, SyncTeX: true, Logger: log.New(&buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if strings.Contains(string(args), "synctex") {
+		t.Error("Unsupported flag was passed:", string(args))
+	}
+	if !strings.Contains(buf.String(), "doesn't support -synctex") {
+		t.Error("Expected a warning, got", buf.String())
+	}
+
+	command, state = fakeLatex(t, `if [ "$1" = --help ]; then echo "-synctex=NUMBER"; exit; fi
+echo "$*" > $STATE/args
+echo "%PDF-1.5" > gotex.pdf`)
+	if _, err = Render("doc", Options{Command: command, SyncTeX: true}); err != nil {
+		t.Fatal(err)
+	}
+	args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if !strings.Contains(string(args), "-synctex=1") {
+		t.Error("Supported flag wasn't passed:", string(args))
+	}
+}
+
+func TestMinTeXLive(t *testing.T) {
+	var command, state = fakeLatex(t, `if [ "$1" = --version ]; then
+	echo "pdfTeX 3.14159265-2.6-1.40.18 (TeX Live 2017/Debian) (preloaded format=pdflatex)"
+	exit
+fi
+echo run >> $STATE/runs
+echo "%PDF-1.5" > gotex.pdf`)
+	var _, err = Render("doc", Options{Command: command, MinTeXLive: 2020})
+	if !errors.Is(err, ErrEngineTooOld) {
+		t.Fatal("Expected ErrEngineTooOld, got", err)
+	}
+	if !strings.Contains(err.Error(), "requires TeX Live >= 2020") ||
+		!strings.Contains(err.Error(), "from TeX Live 2017") {
+		t.Error("Error should give both versions:", err)
+	}
+	if runs := countRuns(t, state); runs != 0 {
+		t.Error("Old engine should not be run, ran", runs)
+	}
+
+	if _, err = Render("doc", Options{Command: command, MinTeXLive: 2017}); err != nil {
+		t.Error("New enough engine should pass:", err)
+	}
+
+	command, _ = fakeLatex(t, `echo "MiKTeX-pdfTeX 4.10 (MiKTeX 22.3)"`)
+	_, err = Render("doc", Options{Command: command, MinTeXLive: 2020})
+	if !errors.Is(err, ErrEngineTooOld) || !strings.Contains(err.Error(), "unknown") {
+		t.Error("Unknown version should fail, got", err)
+	}
+}
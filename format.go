@@ -0,0 +1,160 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// formatName is the job name of precompiled formats. The format file is
+// linked into each render's temporary directory under this name, where LaTeX
+// finds it with -fmt.
+const formatName = "gotexfmt"
+
+// formatCache holds a precompiled LaTeX format for one preamble. Loading a
+// format is much faster than processing the \documentclass and \usepackage
+// lines of the preamble on every run, which matters when many documents share
+// the same preamble. A formatCache is safe for concurrent use; the format is
+// built once, on first use, and rebuilt if the preamble or engine changes.
+type formatCache struct {
+	mu sync.Mutex
+	// current is the most recently requested format, or nil if there is none.
+	current *format
+}
+
+// format is a precompiled format, which may still be being built.
+type format struct {
+	// key identifies what the format was built from.
+	key string
+	// ready is closed once the build has finished.
+	ready chan struct{}
+	// dir holds the format file, or is "" if the build failed.
+	dir string
+	// err is the error from building the format, if it failed.
+	err error
+}
+
+// remove waits for the format to be built and removes it.
+func (f *format) remove() {
+	<-f.ready
+	if f.dir != "" {
+		_ = os.RemoveAll(f.dir)
+	}
+}
+
+// use splits document into preamble and body and links a format for the
+// preamble into dir, building it first if needed. It returns the body, which
+// should be compiled with the format, and true on success. If the document has
+// no \begin{document} or its preamble can't be precompiled, use returns
+// false and the document should be compiled normally.
+func (c *formatCache) use(document string, options Options, dir string) (string, bool) {
+	var i = strings.Index(document, `\begin{document}`)
+	if i < 0 {
+		return document, false
+	}
+	var preamble, body = document[:i], document[i:]
+	// The format includes any class files the preamble loads, so a change to
+	// them needs a new format too.
+	var key = options.Command + "\x00" + preamble + "\x00" + classFilesKey(options)
+
+	// Build on first use or when the preamble changes. The build runs
+	// without holding the lock, so renders that don't need it aren't held
+	// up; renders that need the same format wait for it. A failed build is
+	// remembered, so it isn't retried for every document.
+	c.mu.Lock()
+	var f = c.current
+	if f == nil || f.key != key {
+		var old = f
+		f = &format{key: key, ready: make(chan struct{})}
+		c.current = f
+		c.mu.Unlock()
+		f.dir, f.err = buildFormat(preamble, options)
+		if f.err != nil {
+			logf(options, "gotex: %v; compiling the preamble normally", f.err)
+		}
+		close(f.ready)
+		if old != nil {
+			old.remove()
+		}
+	} else {
+		c.mu.Unlock()
+	}
+	<-f.ready
+	if f.err != nil {
+		return document, false
+	}
+	// Link rather than share the file, so that the format can be replaced
+	// while earlier renders are still using it.
+	var err = linkOrCopy(path.Join(f.dir, formatName+".fmt"),
+		path.Join(dir, formatName+".fmt"))
+	if err != nil {
+		return document, false
+	}
+	return body, true
+}
+
+// close removes the current format.
+func (c *formatCache) close() {
+	c.mu.Lock()
+	var f = c.current
+	c.current = nil
+	c.mu.Unlock()
+	if f != nil {
+		f.remove()
+	}
+}
+
+// buildFormat runs LaTeX in initex mode to dump a format containing preamble.
+// It returns the temporary directory holding the format file.
+func buildFormat(preamble string, options Options) (string, error) {
+	var dir, err = makeTempDir(options)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path.Join(dir, formatName+".tex"), []byte(preamble), 0644)
+	if err == nil {
+		err = writeClassFiles(dir, options)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+
+	// Start from the engine's own LaTeX format, read the preamble, and dump
+	// the result, e.g. "&pdflatex gotexfmt.tex\dump".
+	var cmd = niceCommand(options, options.Command, "-ini", "-jobname="+formatName,
+		"-halt-on-error", "&"+engine(options)+" "+formatName+".tex\\dump")
+	cmd.Dir = dir
+	cmd.Env = latexEnv(preamble, options)
+	err = cmd.Run()
+	if err != nil {
+		// Report the first error from the log, since the directory is
+		// removed rather than leaked on every failed build.
+		var errs = parseLogFile(path.Join(dir, formatName+".log"), options.LogTail)
+		_ = os.RemoveAll(dir)
+		if len(errs) > 0 {
+			return "", fmt.Errorf("gotex: precompiling the preamble failed: %s", errs[0].Message)
+		}
+		return "", fmt.Errorf("gotex: precompiling the preamble failed: %v", err)
+	}
+	return dir, nil
+}
+
+// linkOrCopy hard links src to dst, falling back to copying if they are on
+// different filesystems.
+func linkOrCopy(src, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	var data, err = ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
+}
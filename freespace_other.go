@@ -0,0 +1,12 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package gotex
+
+// freeSpace is not implemented on this platform, so the check is skipped.
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bufio"
+	"image"
+	// Register the decoders for the raster formats LaTeX can include.
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image describes an image the document included, such as with
+// \includegraphics.
+type Image struct {
+	// Path is the file LaTeX read, as it was recorded. Relative paths are
+	// relative to the temporary directory LaTeX ran in.
+	Path string
+	// Format is the image format, from the file extension: "png", "jpg",
+	// "jpeg", "pdf", or "eps".
+	Format string
+	// Width and Height are the size in pixels of PNG and JPEG images, or 0
+	// for vector formats and unreadable files. Together with the size the
+	// image is printed at, they give its effective resolution.
+	Width  int
+	Height int
+}
+
+// imageFormats are the extensions of image files that LaTeX engines include.
+var imageFormats = map[string]bool{
+	"png":  true,
+	"jpg":  true,
+	"jpeg": true,
+	"pdf":  true,
+	"eps":  true,
+}
+
+// getImagesFromRecorder returns the images listed in the .fls file that
+// -recorder writes in dir.
+func getImagesFromRecorder(dir string) []Image {
+	var file, err = os.Open(filepath.Join(dir, "gotex.fls"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var images []Image
+	var seen = map[string]bool{}
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var name = strings.TrimPrefix(scanner.Text(), "INPUT ")
+		if name == scanner.Text() || seen[name] {
+			continue
+		}
+		var format = strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+		if !imageFormats[format] {
+			continue
+		}
+		seen[name] = true
+		var img = Image{Path: name, Format: format}
+		var full = name
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(dir, full)
+		}
+		if f, err := os.Open(full); err == nil {
+			if config, _, err := image.DecodeConfig(f); err == nil {
+				img.Width, img.Height = config.Width, config.Height
+			}
+			f.Close()
+		}
+		images = append(images, img)
+	}
+	return images
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writePNG writes a blank PNG image of the given size.
+func writePNG(t *testing.T, name string, width, height int) {
+	var file, err = os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordImages(t *testing.T) {
+	var base = t.TempDir()
+	var logo = filepath.Join(base, "logo.png")
+	writePNG(t, logo, 120, 80)
+
+	var command, state = fakeLatex(t, `echo "$*" > $STATE/args
+echo "PWD $PWD" > gotex.fls
+echo "INPUT /usr/share/texlive/texmf-dist/tex/latex/base/article.cls" >> gotex.fls
+echo "INPUT `+logo+`" >> gotex.fls
+echo "INPUT `+logo+`" >> gotex.fls
+echo "INPUT ./figure.pdf" >> gotex.fls
+echo "OUTPUT gotex.pdf" >> gotex.fls
+echo "%PDF-1.5" > figure.pdf
+echo "%PDF-1.5" > gotex.pdf`)
+	var result Result
+	var _, err = Render("doc", Options{Command: command, RecordImages: true, Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []Image{
+		{Path: logo, Format: "png", Width: 120, Height: 80},
+		{Path: "./figure.pdf", Format: "pdf"},
+	}
+	if !reflect.DeepEqual(result.Images, want) {
+		t.Errorf("Wrong images: %+v", result.Images)
+	}
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if string(args) != "-jobname=gotex -halt-on-error -recorder\n" {
+		t.Errorf("Wrong args: %q", args)
+	}
+
+	requireLatex(t, "pdflatex")
+	_, err = Render(`\documentclass{article}
+\usepackage{graphicx}
+\begin{document}
+\includegraphics{logo.png}
+\end{document}
+`, Options{BaseDir: base, RecordImages: true, Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Images) != 1 || result.Images[0].Format != "png" ||
+		result.Images[0].Width != 120 || filepath.Base(result.Images[0].Path) != "logo.png" {
+		t.Errorf("Wrong images: %+v", result.Images)
+	}
+}
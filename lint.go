@@ -0,0 +1,56 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// lint runs the Lint command, if any, on document in dir. Its output is
+// saved as gotex.lint for fillResult to report.
+func lint(document, dir string, options Options) error {
+	if len(options.Lint) == 0 {
+		return nil
+	}
+	var source = path.Join(dir, "gotex.tex")
+	if err := ioutil.WriteFile(source, []byte(document), 0644); err != nil {
+		return err
+	}
+	var args = append([]string{}, options.Lint[1:]...)
+	args = append(args, source)
+	var cmd = exec.Command(options.Lint[0], args...)
+	cmd.Dir = dir
+	var output, err = cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return fmt.Errorf("gotex: can't run linter %s: %v", options.Lint[0], err)
+	}
+	if werr := ioutil.WriteFile(path.Join(dir, "gotex.lint"), output, 0644); werr != nil {
+		return werr
+	}
+	if options.LintStrict && (err != nil || len(getLintFindings(dir)) > 0) {
+		return fmt.Errorf("gotex: lint with %s found problems:\n%s",
+			options.Lint[0], output)
+	}
+	return nil
+}
+
+// getLintFindings returns the non-empty lines of the linter output saved in
+// dir.
+func getLintFindings(dir string) []string {
+	var data, err = ioutil.ReadFile(path.Join(dir, "gotex.lint"))
+	if err != nil {
+		return nil
+	}
+	var findings []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			findings = append(findings, line)
+		}
+	}
+	return findings
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "%PDF-1.5" > gotex.pdf`)
+	// The stub linter complains about every line containing "teh".
+	var linter = filepath.Join(t.TempDir(), "linter")
+	var script = "#!/bin/sh\ngrep -n teh \"$2\" | sed \"s/^/$1: /\"\n"
+	if err := ioutil.WriteFile(linter, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var document = "\\documentclass{article}\n\\begin{document}\nteh end\n\\end{document}\n"
+	var result Result
+	var _, err = Render(document, Options{
+		Command: command,
+		Lint:    []string{linter, "-q"},
+		Result:  &result,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []string{"-q: 3:teh end"}
+	if !reflect.DeepEqual(result.Lint, want) {
+		t.Errorf("Wrong findings: %q", result.Lint)
+	}
+
+	var tmp = t.TempDir()
+	_, err = Render(document, Options{
+		Command:    command,
+		Lint:       []string{linter, "-q"},
+		LintStrict: true,
+		Result:     &result,
+		TempDir:    tmp,
+	})
+	if err == nil || !strings.Contains(err.Error(), "3:teh end") {
+		t.Error("Strict lint should fail with the findings, got", err)
+	}
+	if !reflect.DeepEqual(result.Lint, want) {
+		t.Errorf("Findings should be reported on failure: %q", result.Lint)
+	}
+	if runs := countRuns(t, state); runs != 1 {
+		t.Error("Strict lint failure should not compile, ran", runs-1)
+	}
+
+	// A clean document passes strict lint.
+	_, err = Render("the end", Options{
+		Command:    command,
+		Lint:       []string{linter, "-q"},
+		LintStrict: true,
+		Result:     &result,
+	})
+	if err != nil || result.Lint != nil {
+		t.Error("Clean document should pass:", err, result.Lint)
+	}
+
+	_, err = Render(document, Options{
+		Command: command,
+		Lint:    []string{filepath.Join(state, "missing")},
+		TempDir: tmp,
+	})
+	if err == nil || !strings.Contains(err.Error(), "can't run linter") {
+		t.Error("Missing linter should fail, got", err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Lint failures should not leave directories behind, found", len(files))
+	}
+}
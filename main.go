@@ -35,18 +35,27 @@ package gotex
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Options contains the knobs used to change gotex's behavior.
 type Options struct {
 	// Command is the executable to run. It defaults to "pdflatex". Set this to
 	// a full path if $PATH will not be defined in your app's environment.
+	// Right-to-left documents, such as Arabic or Hebrew with bidi or
+	// polyglossia, need xelatex or lualatex; when Command is not set and
+	// Analyze finds such a document, xelatex is used.
 	Command string
 	// Runs determines how many times Command is run. This is needed for
 	// documents that use refrences and packages that require multiple passes.
@@ -58,6 +67,298 @@ type Options struct {
 	// such as image files that are needed to compile the document. It is added
 	// to $TEXINPUTS for the LaTeX process.
 	Texinputs string
+
+	// StopWhenStable makes automagic mode stop as soon as two consecutive runs
+	// produce a byte-identical PDF, even if the log still asks for a rerun.
+	// This guards against packages that request reruns spuriously. Note that
+	// LaTeX embeds timestamps in the PDF, so this only takes effect when they
+	// are pinned, e.g. with $SOURCE_DATE_EPOCH and $FORCE_SOURCE_DATE.
+	StopWhenStable bool
+	// StopOnOscillation makes automagic mode stop, logging a warning, when
+	// the .aux file repeats the contents it had after an earlier run. Some
+	// documents never converge, e.g. when page numbers change the table of
+	// contents, which changes the page numbers again. Without this option
+	// such documents always use up every run.
+	StopOnOscillation bool
+
+	// Preamble is LaTeX inserted into the document just before
+	// \begin{document}, such as extra \usepackage lines.
+	Preamble string
+	// Source, if set, receives the exact document source that is fed to
+	// LaTeX, after all of the modifications requested by these options. This
+	// is useful for reproducing failures by hand.
+	Source io.Writer
+
+	// TempDir is the directory in which gotex creates its temporary working
+	// directories. It defaults to the system's temporary directory. Before
+	// compiling, gotex checks that it is writable and has some free space.
+	TempDir string
+
+	// TempPrefix is included in the names of the temporary directories,
+	// such as "gotex-invoice-42-7-123456" for TempPrefix "invoice-42",
+	// along with a counter of the directories made by this process, so that
+	// concurrent renders can be told apart in $TMPDIR listings and logs. Set
+	// it to an ID of the render, such as a request ID.
+	TempPrefix string
+
+	// TempName, if set, makes gotex work in a directory with this fixed
+	// name inside TempDir, such as "gotex-debug", instead of a new randomly
+	// named one. The directory is emptied at the start of each render and
+	// kept afterwards, so the log and other files of the last render are
+	// always at the same path, which helps when debugging with scripts. To
+	// protect existing directories, gotex refuses to use one it didn't make.
+	// Renders in this process that use the same directory wait for each
+	// other, and a reader from RenderToReader holds it until closed;
+	// separate processes must not share it.
+	TempName string
+
+	// BaseDir is the directory the document would live in if it were a file.
+	// It is added to $TEXINPUTS, so relative \input and \includegraphics paths
+	// work. It also enables support for the subfiles package: when the
+	// document is a subfile, such as \documentclass[../main.tex]{subfiles},
+	// the main document is found relative to BaseDir and its directory is
+	// added to $TEXINPUTS as well.
+	BaseDir string
+
+	// Logger, if set, receives diagnostic messages about the render, such as
+	// each LaTeX run and where the log was left on failure.
+	Logger Logger
+
+	// Trace turns on macro and command tracing with \tracingmacros and
+	// \tracingcommands, which makes LaTeX write every expansion from
+	// \begin{document} onwards to its log. This is very verbose and is meant
+	// for debugging templates. Use Log to retrieve the trace.
+	Trace bool
+	// Log, if set, receives the contents of the LaTeX log file from the
+	// final run, whether or not it succeeded.
+	Log io.Writer
+
+	// Creator identifies the application that produced the document, such as
+	// "invoicer 1.4.2 (3fa9c1e)". It is written to the /Creator field of the
+	// PDF's document information, through hyperref if the document loads it.
+	// Any characters are allowed.
+	Creator string
+
+	// AllowPackages, if not nil, lists the only packages the document may load
+	// with \usepackage or \RequirePackage. DenyPackages lists packages it may
+	// not load. Documents that break these rules are rejected with
+	// ErrPackageNotAllowed before LaTeX is run, e.g. to forbid shellesc or
+	// catchfile. This is a best-effort filter, not a sandbox: it only finds
+	// \usepackage and \RequirePackage in the document itself, not in
+	// Preamble, so untrusted documents can still load packages with \input,
+	// \csname usepackage\endcsname, other packages, or the document class.
+	AllowPackages []string
+	DenyPackages  []string
+
+	// NormalizeLineEndings converts Windows CRLF line endings in the document
+	// to LF before compiling, since some packages trip over the stray
+	// carriage returns. The contents of verbatim-like environments (verbatim,
+	// Verbatim, lstlisting, minted, and their starred forms) are left
+	// untouched, as they reproduce their input byte for byte. Lone CRs are
+	// not changed.
+	NormalizeLineEndings bool
+
+	// OutputComment is passed to LaTeX with -output-comment, to tag the
+	// output with provenance information. Note that pdfTeX only writes it to
+	// DVI output; when producing a PDF it is accepted but has no effect, so
+	// use Creator to tag PDFs. It is limited to 255 bytes.
+	OutputComment string
+
+	// Result, if not nil, is filled in with details about the render, even
+	// if it fails. Since it is written to, don't use the same Result for
+	// concurrent renders.
+	Result *Result
+
+	// PostValidate is a command, with arguments, that checks the produced
+	// PDF, such as []string{"qpdf", "--check"} or []string{"verapdf"}. The
+	// path to the PDF is appended to the arguments. If the command exits
+	// with a nonzero status, the render fails with an error that includes
+	// the command's output and the path to the PDF, which is left in place
+	// for inspection.
+	PostValidate []string
+
+	// LogTail, if positive, limits parsing of the LaTeX log for errors and
+	// Result to its last LogTail bytes. Logs of very long documents can be
+	// huge, while the interesting errors are usually near the end, so this
+	// bounds the time and memory spent parsing. By default the whole log is
+	// parsed.
+	LogTail int64
+
+	// Memory overrides TeX's memory and capacity parameters from texmf.cnf,
+	// such as extra_mem_top, main_memory, pool_size, or save_size. This helps
+	// documents that fail with "TeX capacity exceeded" without editing the
+	// system configuration. The parameters are passed as environment
+	// variables, which take precedence over texmf.cnf for pdfTeX and XeTeX.
+	// Note that main_memory is fixed when a format is built, so prefer
+	// extra_mem_top and extra_mem_bot. LuaTeX allocates memory dynamically and
+	// has no such limits, so Memory is ignored for lualatex.
+	Memory map[string]int
+
+	// AfterRender, if set, is called once at the end of every render, whether
+	// or not it succeeded, with the Result and the error being returned, if
+	// any. It is a single place for metrics, logging, or cleanup.
+	AfterRender func(Result, error)
+
+	// StartView and PageLayout control how PDF viewers first display the
+	// document. StartView is one of "Fit", "FitH", "FitV", "FitB", "FitBH",
+	// or "FitBV", e.g. "FitH" to fit the page width. PageLayout is one of
+	// "SinglePage", "OneColumn", "TwoColumnLeft", "TwoColumnRight",
+	// "TwoPageLeft", or "TwoPageRight". They are set with hyperref's
+	// \hypersetup, and hyperref is loaded if the document doesn't already.
+	StartView  string
+	PageLayout string
+
+	// Fallback lists LaTeX commands to retry with, in order, when Command
+	// fails because of the engine itself, e.g. []string{"xelatex"}. Such
+	// failures are errors like fontspec or Unicode support requiring XeTeX or
+	// LuaTeX; other errors, like syntax errors, are returned right away.
+	Fallback []string
+
+	// CollectAllErrors makes LaTeX continue past errors, so that all of them
+	// are reported in Error.Errors. By default LaTeX is run with
+	// -halt-on-error and stops at the first error, so only that one is
+	// reported. With this option it is run with -interaction=nonstopmode
+	// instead. The render fails either way.
+	CollectAllErrors bool
+
+	// EnsureUTF8 loads \usepackage[utf8]{inputenc} and
+	// \usepackage[T1]{fontenc} right after \documentclass, unless the
+	// document already loads them, so that UTF-8 text with accented
+	// characters renders properly with pdflatex. It has no effect with
+	// xelatex and lualatex, which support UTF-8 natively.
+	EnsureUTF8 bool
+
+	// ShellEscape passes -shell-escape, allowing the document to run
+	// arbitrary commands with \write18. Never use it with untrusted input.
+	ShellEscape bool
+	// NoShellEscape passes -no-shell-escape, which disables \write18
+	// entirely. This overrides TeX distributions that enable restricted
+	// shell escape by default in texmf.cnf, for defense in depth with
+	// untrusted input. It can't be combined with ShellEscape.
+	NoShellEscape bool
+
+	// Durable makes RenderToFile flush the output file and its directory to
+	// disk with fsync before returning, so the PDF survives a crash or power
+	// loss. This can add milliseconds or more per render, depending on the
+	// storage, so only use it where durability matters.
+	Durable bool
+
+	// ImageConverter is the pdftocairo executable RenderEquation uses to
+	// convert PDFs to images. It defaults to "pdftocairo".
+	ImageConverter string
+
+	// Priority is the niceness to run LaTeX with on Unix, from -20 to 19.
+	// Positive values lower its priority, so that bulk rendering doesn't
+	// starve interactive work; negative values need privileges. LaTeX is
+	// run under nice(1) to apply it. 0 leaves the priority unchanged. On
+	// other platforms it is ignored with a warning to the Logger.
+	Priority int
+
+	// Tagged produces a tagged PDF, as needed for accessibility standards
+	// such as PDF/UA, by adding \DocumentMetadata{testphase=phase-III}
+	// before \documentclass, unless the document already sets metadata.
+	// This needs LaTeX 2022-06 or newer. Tagging works best with LuaTeX, so
+	// lualatex is used when Command is not set; with pdflatex, fewer
+	// structures are tagged.
+	Tagged bool
+
+	// Artifacts lists glob patterns, such as "*.log" or "*.synctex.gz", of
+	// files that RenderWithArtifacts returns along with the PDF. Patterns
+	// are matched against paths relative to the temporary directory, using
+	// the syntax of filepath.Match.
+	Artifacts []string
+
+	// FontRetries is how many times to retry a LaTeX run that failed while
+	// generating fonts with mktexpk, mktextfm, or mktexmf. When several
+	// renders start at once, e.g. in freshly scaled containers sharing a
+	// writable font cache, they race to generate the same fonts and some
+	// fail transiently on existing files or locks. Other failures, including
+	// fonts that can't be generated at all, are not retried. Retries count
+	// towards Result.Runs. FontRetryDelay is
+	// how long to wait before each retry; it defaults to half a second.
+	FontRetries    int
+	FontRetryDelay time.Duration
+
+	// ClassFiles maps file names, such as "corp.cls" or "corp.sty", to
+	// contents that are written into the directory LaTeX runs in before
+	// compiling, so that \documentclass{corp} or \usepackage{corp} resolve
+	// without installing the files or setting Texinputs. Names must be plain
+	// file names ending in .cls or .sty.
+	ClassFiles map[string][]byte
+
+	// SyncTeX passes -synctex=1, so that LaTeX writes gotex.synctex.gz for
+	// jumping between the source and the PDF in editors and viewers; fetch
+	// it with RenderWithArtifacts. If the engine doesn't support the flag,
+	// as checked with SupportsFlag, it is left out with a warning to the
+	// Logger.
+	SyncTeX bool
+
+	// RecordImages passes -recorder, so that LaTeX records the files it
+	// reads, and reports the images among them in Result.Images, e.g. to
+	// flag low resolution images before printing.
+	RecordImages bool
+
+	// Lint is a linter command, with arguments, to run on the document
+	// before compiling it, such as []string{"chktex", "-q"} or
+	// []string{"lacheck"}. The document is written to gotex.tex in the
+	// temporary directory and its path appended to the arguments. The
+	// linter's output is reported in Result.Lint, one finding per line. If
+	// LintStrict is set, the render fails without compiling when the linter
+	// reports anything or exits with a nonzero status.
+	Lint       []string
+	LintStrict bool
+
+	// MinTeXLive, if set, is the oldest TeX Live release the document works
+	// with, such as 2020. The engine's version is checked before compiling,
+	// and the render fails with ErrEngineTooOld if it's older or its version
+	// can't be determined, rather than with a cryptic error about an
+	// undefined command.
+	MinTeXLive int
+
+	// Geometry sets the paper size and margins with the geometry package,
+	// e.g. "a4paper,margin=1in" or "letterpaper,landscape", without editing
+	// the document. The package is loaded if the document doesn't already
+	// load it; if it does, the settings are applied with \geometry and
+	// override the document's.
+	Geometry string
+
+	// SourceHeader is put at the very top of the document as TeX comments,
+	// e.g. "Generated by invoicer at 2017-03-01T12:00:00Z", so that the
+	// source can be traced back when it's extracted for debugging. A % is
+	// added to each line that doesn't start with one, so it never affects
+	// the output. Since it becomes part of the preamble, a header that
+	// changes on every render defeats the precompiled formats of a Pool.
+	SourceHeader string
+
+	// MaxUsage, if positive, fails the render with ErrUsageExceeded when the
+	// document uses more than this fraction of any of TeX's capacities, such
+	// as main memory or the string pool, as reported at the end of the log.
+	// E.g. 0.8 catches documents that are within 20% of failing with "TeX
+	// capacity exceeded". WarnUsage is the same, but only logs a warning to
+	// the Logger. The usage is also reported in Result.Usage.
+	MaxUsage  float64
+	WarnUsage float64
+
+	// FailOnNonConvergence makes the render fail with ErrNotConverged when,
+	// in automagic mode, the document still needs another run after the
+	// maximum number of runs, so cross-references may be wrong. By default
+	// the PDF is returned anyway, with a warning to the Logger.
+	FailOnNonConvergence bool
+
+	// draft runs LaTeX with -draftmode, which checks the document without
+	// writing a PDF, if the engine supports it.
+	draft bool
+	// workDir is a persistent directory to run LaTeX in instead of a new
+	// temporary one. It is set internally when rendering through a Workspace.
+	workDir string
+	// format is the name of a precompiled format to load instead of the
+	// default one. It is set internally when rendering through a Pool.
+	format string
+}
+
+// Logger receives diagnostic messages from gotex. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
 }
 
 // Render takes the LaTeX document to be rendered as a string. It returns the
@@ -65,19 +366,184 @@ type Options struct {
 // temporary directory intact so you can check the log file to see what
 // happened. The error will tell you where to find it.
 func Render(document string, options Options) ([]byte, error) {
+	return render(document, options, nil)
+}
+
+// DetermineRuns renders the document in automagic mode and returns how many
+// runs it needed. When a template is rendered repeatedly, determine this once
+// and set Options.Runs to it, which is faster than detecting it every time.
+// Options.Runs is ignored. Options.Result, if set, is filled in as usual.
+func DetermineRuns(document string, options Options) (int, error) {
+	options.Runs = 0
+	if options.Result == nil {
+		options.Result = &Result{}
+	}
+	var result = options.Result
+	if _, err := Render(document, options); err != nil {
+		return 0, err
+	}
+	// Retries don't need repeating on later renders.
+	return result.Runs - result.FontRetries, nil
+}
+
+// render does the work of Render. If formats is not nil, the document's
+// preamble is loaded from a cached precompiled format when possible.
+func render(document string, options Options, formats *formatCache) (output []byte, err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	dir, err := compile(document, options, formats)
+	if err != nil {
+		return nil, err
+	}
+	// Clean up the temp directory.
+	defer removeDir(dir, options)
+
+	// Slurp the output.
+	return ioutil.ReadFile(path.Join(dir, "gotex.pdf"))
+}
+
+// compile runs LaTeX on the document in a new temporary directory as many
+// times as needed, and returns the directory. The PDF is left in it as
+// gotex.pdf, and the caller is responsible for removing it. If LaTeX fails
+// because of the engine, compile retries with each Fallback command in turn.
+func compile(document string, options Options, formats *formatCache) (string, error) {
 	// Set default options.
+	var analysis = Analyze(document)
 	if options.Command == "" {
 		options.Command = "pdflatex"
+		if options.Tagged {
+			options.Command = "lualatex"
+		} else if analysis.RTL {
+			logf(options, "gotex: document uses right-to-left scripts, using xelatex")
+			options.Command = "xelatex"
+		}
+	} else if analysis.RTL && (engine(options) == "pdflatex" || engine(options) == "latex") {
+		logf(options, "gotex: document uses right-to-left scripts, "+
+			"which need xelatex or lualatex; %s will likely fail", options.Command)
 	}
 
-	// Create the temporary directory where LaTeX will dump its ugliness.
-	var dir, err = ioutil.TempDir("", "gotex-")
-	if err != nil {
-		return nil, err
+	// Remember the requested flags, since checkFlags turns off those the
+	// engine doesn't support, and a Fallback engine may support them.
+	var syncTeX, draft = options.SyncTeX, options.draft
+	checkFlags(&options)
+
+	if len(options.OutputComment) > 255 {
+		return "", errors.New("gotex: OutputComment is longer than 255 bytes")
+	}
+	if options.ShellEscape && options.NoShellEscape {
+		return "", errors.New("gotex: ShellEscape and NoShellEscape are mutually exclusive")
+	}
+	if err := checkVersion(options); err != nil {
+		return "", err
+	}
+	if err := checkMemory(options); err != nil {
+		return "", err
+	}
+	if len(options.Memory) > 0 && engine(options) == "lualatex" {
+		logf(options, "gotex: ignoring Memory, lualatex allocates memory dynamically")
+	}
+	if err := checkClassFiles(options); err != nil {
+		return "", err
+	}
+	if err := checkViewer(options); err != nil {
+		return "", err
+	}
+	if err := checkPackages(analysis, options); err != nil {
+		return "", err
+	}
+	if analysis.ShellEscape && !options.ShellEscape {
+		logf(options, "gotex: document uses \\write18, which needs "+
+			"ShellEscape to run commands; the output may be incomplete")
+	}
+
+	document = prepareDocument(document, options)
+	if options.Source != nil {
+		if _, err := io.WriteString(options.Source, document); err != nil {
+			return "", err
+		}
+	}
+
+	// A fixed TempName directory is claimed until the caller is done with
+	// it and calls removeDir, or until the render fails.
+	if options.TempName != "" && options.workDir == "" {
+		if err := claimTempName(options); err != nil {
+			return "", err
+		}
+	}
+
+	var dir, err = compileWith(document, options, formats)
+	for _, command := range options.Fallback {
+		var latexErr *Error
+		if !errors.As(err, &latexErr) || !isEngineError(latexErr.Errors) {
+			break
+		}
+		logf(options, "gotex: %s can't compile the document, retrying with %s",
+			options.Command, command)
+		// The failure is superseded, so its directory isn't needed.
+		discardDir(filepath.Dir(latexErr.Log), options)
+		options.Command = command
+		// Flags are supported differently by each engine.
+		options.SyncTeX, options.draft = syncTeX, draft
+		checkFlags(&options)
+		// Precompiled formats only work with the engine that built them.
+		dir, err = compileWith(document, options, nil)
+	}
+	if err != nil && options.TempName != "" && options.workDir == "" {
+		releaseTempName(options)
+	}
+	return dir, err
+}
+
+// compileWith does the work of compile for one LaTeX command, given the
+// prepared document.
+func compileWith(document string, options Options, formats *formatCache) (string, error) {
+	// Create the temporary directory where LaTeX will dump its ugliness,
+	// unless we were given a persistent one.
+	var dir = options.workDir
+	var err error
+	if dir == "" && options.TempName != "" {
+		dir, err = makeNamedTempDir(options)
+		if err != nil {
+			return "", err
+		}
+	} else if dir == "" {
+		dir, err = makeTempDir(options)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// Don't let the previous PDF pass for this one, in case LaTeX
+		// doesn't write one.
+		err = os.Remove(path.Join(dir, "gotex.pdf"))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
 	}
 	// The directory cleanup is purposefully not deferred here because we need
 	// to leave the log file for postmortem in the case of failure.
 
+	if err = writeClassFiles(dir, options); err != nil {
+		discardDir(dir, options)
+		return "", err
+	}
+	if err = lint(document, dir, options); err != nil {
+		logf(options, "gotex: %v", err)
+		fillResult(dir, 0, 0, time.Now(), options)
+		// The error carries the linter's output, so the directory isn't
+		// needed for postmortem.
+		discardDir(dir, options)
+		return "", err
+	}
+
+	// Swap the preamble for a precompiled format if one is available.
+	if formats != nil {
+		if body, ok := formats.use(document, options, dir); ok {
+			document = body
+			options.format = formatName
+		}
+	}
+
 	// Unless a number was given, don't let automagic mode run more than this
 	// many times.
 	var maxRuns = 5
@@ -85,59 +551,298 @@ func Render(document string, options Options) ([]byte, error) {
 		maxRuns = options.Runs
 	}
 	// Keep running until the document is finished or we hit an arbitrary limit.
-	var runs int
-	for rerun := true; rerun && runs < maxRuns; runs++ {
+	var start = time.Now()
+	var runs, retries int
+	var lastHash []byte
+	var auxHashes = map[string]bool{}
+	var rerun = true
+	for ; rerun && runs < maxRuns; runs++ {
+		logf(options, "gotex: run %d of at most %d in %s", runs+1, maxRuns, dir)
 		err = runLatex(document, options, dir)
+		for retry := 0; err != nil && retry < options.FontRetries && isFontCacheError(dir); retry++ {
+			logf(options, "gotex: font generation failed, retrying in %v", fontRetryDelay(options))
+			time.Sleep(fontRetryDelay(options))
+			err = runLatex(document, options, dir)
+			retries++
+		}
 		if err != nil {
-			return nil, err
+			logf(options, "gotex: %v", err)
+			logDir(dir, options)
+			_ = copyLog(dir, options)
+			fillResult(dir, runs+1+retries, retries, start, options)
+			return "", err
 		}
 		// If in automagic mode, determine whether we need to run again.
 		if options.Runs == 0 {
 			rerun = needsRerun(dir)
+			if rerun && options.StopWhenStable {
+				var hash = hashFile(path.Join(dir, "gotex.pdf"))
+				rerun = hash == nil || !bytes.Equal(hash, lastHash)
+				lastHash = hash
+			}
+			if rerun && options.StopOnOscillation {
+				var hash = string(hashFile(path.Join(dir, "gotex.aux")))
+				if auxHashes[hash] {
+					logf(options, "gotex: warning: .aux file is oscillating, "+
+						"stopping after %d runs", runs+1)
+					rerun = false
+				}
+				if hash != "" {
+					auxHashes[hash] = true
+				}
+			}
 		}
 	}
 
-	// Slurp the output.
-	output, err := ioutil.ReadFile(path.Join(dir, "gotex.pdf"))
-	if err != nil {
-		return nil, err
+	fillResult(dir, runs+retries, retries, start, options)
+	// These errors explain themselves, so the directory isn't kept for
+	// postmortem, except when validation fails: then the error names the
+	// PDF, so it can be inspected.
+	if err = copyLog(dir, options); err != nil {
+		discardDir(dir, options)
+		return "", err
+	}
+	if rerun && options.Runs == 0 {
+		logf(options, "gotex: warning: document still needs rerunning after %d runs", runs)
+		if options.FailOnNonConvergence {
+			discardDir(dir, options)
+			return "", fmt.Errorf("gotex: %w after %d runs", ErrNotConverged, runs)
+		}
+	}
+	if err = checkUsage(dir, options); err != nil {
+		logf(options, "gotex: %v", err)
+		discardDir(dir, options)
+		return "", err
 	}
+	if err = postValidate(dir, options); err != nil {
+		logf(options, "gotex: %v", err)
+		return "", err
+	}
+	return dir, nil
+}
 
-	// Clean up the temp directory.
-	_ = os.RemoveAll(dir)
-	return output, nil
+// removeDir removes a directory that compile returned, unless it is the
+// persistent working directory of a Workspace or the fixed TempName one,
+// which is released for other renders instead. Callers must call it once
+// they are done with the directory.
+func removeDir(dir string, options Options) {
+	discardDir(dir, options)
+	if options.TempName != "" && dir != options.workDir {
+		releaseTempName(options)
+	}
+}
+
+// discardDir removes a directory during a render, unless it is persistent,
+// without releasing a fixed TempName directory.
+func discardDir(dir string, options Options) {
+	if dir != options.workDir && options.TempName == "" {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// logf writes a message to the configured logger, if any.
+func logf(options Options, format string, v ...interface{}) {
+	if options.Logger != nil {
+		options.Logger.Printf(format, v...)
+	}
 }
 
 // runLatex does the actual work of spawning the child and waiting for it.
 func runLatex(document string, options Options, dir string) error {
-	var args = []string{"-jobname=gotex", "-halt-on-error"}
-
 	// Prepare the command.
-	var cmd = exec.Command(options.Command, args...)
+	var cmd = niceCommand(options, options.Command, latexArgs(options)...)
 	// Set the cwd to the temporary directory; LaTeX will write all files there.
 	cmd.Dir = dir
 	// Feed the document to LaTeX over stdin.
 	cmd.Stdin = strings.NewReader(document)
 
-	// Set $TEXINPUTS if requested. The trailing colon means that LaTeX should
-	// include the normal asset directories as well.
-	if options.Texinputs != "" {
-		cmd.Env = append(os.Environ(), "TEXINPUTS="+options.Texinputs+":")
-	}
+	cmd.Env = latexEnv(document, options)
 
 	// Launch and let it finish.
 	var err = cmd.Start()
+	if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+		return fmt.Errorf("gotex: %w: %v", ErrEngineNotFound, err)
+	}
 	if err != nil {
 		return err
 	}
 	err = cmd.Wait()
 	if err != nil {
 		// The actual error is useless, do provide a better one.
-		return errors.New("LaTeX error. Check " + path.Join(dir, "gotex.log"))
+		return &Error{
+			Log:    path.Join(dir, "gotex.log"),
+			Errors: getErrorsFromLog(dir, options.LogTail),
+		}
+	}
+	return nil
+}
+
+// latexArgs returns the command line arguments for LaTeX.
+func latexArgs(options Options) []string {
+	var args = []string{"-jobname=gotex", "-halt-on-error"}
+	if options.CollectAllErrors {
+		args = []string{"-jobname=gotex", "-interaction=nonstopmode"}
+	}
+	if options.format != "" {
+		args = append(args, "-fmt="+options.format)
+	}
+	if options.ShellEscape {
+		args = append(args, "-shell-escape")
+	}
+	if options.NoShellEscape {
+		args = append(args, "-no-shell-escape")
+	}
+	if options.SyncTeX {
+		args = append(args, "-synctex=1")
+	}
+	if options.RecordImages {
+		args = append(args, "-recorder")
+	}
+	if options.draft {
+		args = append(args, "-draftmode")
+	}
+	if options.OutputComment != "" {
+		// No quoting is needed, since no shell is involved.
+		args = append(args, "-output-comment="+options.OutputComment)
+	}
+	return args
+}
+
+// latexEnv returns the environment for the LaTeX process, or nil to inherit
+// ours unchanged.
+func latexEnv(document string, options Options) []string {
+	var env []string
+	// Set $TEXINPUTS if requested. The trailing colon means that LaTeX should
+	// include the normal asset directories as well.
+	if dirs := texinputs(document, options); dirs != "" {
+		env = append(env, "TEXINPUTS="+dirs+":")
+	}
+	env = append(env, memoryEnv(options)...)
+	if env == nil {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
+
+// engine returns the name of the LaTeX engine, such as "pdflatex", from the
+// configured command.
+func engine(options Options) string {
+	var base = filepath.Base(options.Command)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// texinputs returns the directories to add to $TEXINPUTS, separated by colons.
+func texinputs(document string, options Options) string {
+	var dirs []string
+	if options.Texinputs != "" {
+		dirs = append(dirs, options.Texinputs)
+	}
+	// LaTeX runs in the temporary directory, so a relative BaseDir must be
+	// made absolute to mean the same directory.
+	var baseDir = options.BaseDir
+	if baseDir != "" {
+		if abs, err := filepath.Abs(baseDir); err == nil {
+			baseDir = abs
+		}
+		dirs = append(dirs, baseDir)
+	}
+	if main := subfileMainDir(document); main != "" && main != baseDir {
+		dirs = append(dirs, main)
+	}
+	return strings.Join(dirs, ":")
+}
+
+// logDir logs the names and sizes of the files in dir, to help diagnose
+// missing assets and unexpected output after a failure.
+func logDir(dir string, options Options) {
+	if options.Logger == nil {
+		return
+	}
+	logf(options, "gotex: contents of %s:", dir)
+	_ = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		var rel, _ = filepath.Rel(dir, name)
+		logf(options, "gotex:   %s (%d bytes)", rel, info.Size())
+		return nil
+	})
+}
+
+// postValidate runs the PostValidate command, if any, on the PDF in dir.
+func postValidate(dir string, options Options) error {
+	if len(options.PostValidate) == 0 {
+		return nil
+	}
+	var args = append([]string{}, options.PostValidate[1:]...)
+	args = append(args, path.Join(dir, "gotex.pdf"))
+	var cmd = exec.Command(options.PostValidate[0], args...)
+	cmd.Dir = dir
+	var output, err = cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gotex: PDF validation of %s with %s failed: %v\n%s",
+			args[len(args)-1], options.PostValidate[0], err, output)
 	}
 	return nil
 }
 
+// copyLog copies the log file in dir to options.Log, if set.
+func copyLog(dir string, options Options) error {
+	if options.Log == nil {
+		return nil
+	}
+	var file, err = os.Open(path.Join(dir, "gotex.log"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(options.Log, file)
+	return err
+}
+
+// fontRetryDelay returns how long to wait before retrying after a font
+// generation failure.
+func fontRetryDelay(options Options) time.Duration {
+	if options.FontRetryDelay > 0 {
+		return options.FontRetryDelay
+	}
+	return 500 * time.Millisecond
+}
+
+// fontGenerators are the programs kpathsea runs to generate missing fonts.
+var fontGenerators = []string{"mktexpk", "mktextfm", "mktexmf"}
+
+// fontRaceErrors are fragments of the errors font generation fails with when
+// another process is generating the same font or holds a lock on the font
+// cache. Other failures, such as fonts that don't exist, are permanent.
+var fontRaceErrors = []string{
+	"File exists",
+	"Resource temporarily unavailable",
+	"Device or resource busy",
+	"Text file busy",
+	".lock",
+}
+
+// isFontCacheError determines from the log file whether a failed run was
+// generating fonts and failed transiently, because it raced other renders.
+func isFontCacheError(dir string) bool {
+	var data, err = ioutil.ReadFile(path.Join(dir, "gotex.log"))
+	if err != nil {
+		return false
+	}
+	return containsAny(data, fontGenerators) && containsAny(data, fontRaceErrors)
+}
+
+// containsAny determines whether data contains any of the fragments.
+func containsAny(data []byte, fragments []string) bool {
+	for _, s := range fragments {
+		if bytes.Contains(data, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
 // Parse the log file and attempt to determine whether another run is necessary
 // to finish the document.
 func needsRerun(dir string) bool {
@@ -156,3 +861,17 @@ func needsRerun(dir string) bool {
 	}
 	return false
 }
+
+// hashFile returns the SHA-256 digest of a file, or nil if it can't be read.
+func hashFile(name string) []byte {
+	var file, err = os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var h = sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
@@ -4,9 +4,52 @@
 package gotex
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// fakeLatex writes a shell script that stands in for pdflatex, so the logic
+// around LaTeX can be tested without a TeX installation. The script runs in
+// gotex's temporary directory and can keep state in $STATE, which is the
+// returned directory.
+func fakeLatex(t *testing.T, script string) (command string, state string) {
+	state = t.TempDir()
+	command = filepath.Join(state, "fakelatex")
+	var err = ioutil.WriteFile(command,
+		[]byte("#!/bin/sh\nSTATE="+state+"\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return command, state
+}
+
+// requireLatex skips tests that need a real TeX installation if there isn't one.
+func requireLatex(t *testing.T, command string) {
+	if _, err := exec.LookPath(command); err != nil {
+		t.Skip(command, "not available")
+	}
+}
+
+// countRuns returns how many times a fakeLatex script appended to $STATE/runs.
+func countRuns(t *testing.T, state string) int {
+	var data, err = ioutil.ReadFile(filepath.Join(state, "runs"))
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
 func TestRender(t *testing.T) {
 	var document = `
         \documentclass[12pt]{article}
@@ -31,3 +74,283 @@ func TestRender(t *testing.T) {
 		t.Error("Should not product a PDF on invalid document")
 	}
 }
+
+func TestStopWhenStable(t *testing.T) {
+	// This engine always asks for a rerun but produces the same PDF each time.
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "Rerun to get cross-references right." > gotex.log
+echo "%PDF-1.5 stable" > gotex.pdf
+`)
+	var _, err = Render("doc", Options{Command: command, StopWhenStable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := countRuns(t, state); runs != 2 {
+		t.Error("Should stop after 2 identical runs, ran", runs)
+	}
+
+	// Without the option, the spurious rerun requests use up every run.
+	command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "Rerun to get cross-references right." > gotex.log
+echo "%PDF-1.5 stable" > gotex.pdf
+`)
+	_, err = Render("doc", Options{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := countRuns(t, state); runs != 5 {
+		t.Error("Should run the maximum of 5 times, ran", runs)
+	}
+}
+
+func TestOutputComment(t *testing.T) {
+	var command, state = fakeLatex(t, `for arg; do echo "$arg"; done > $STATE/args
+echo "%PDF-1.5" > gotex.pdf`)
+	var comment = `Generated by "billing" for O'Brien; ref #1`
+	var _, err = Render("doc", Options{Command: command, OutputComment: comment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if !strings.Contains(string(args), "\n-output-comment="+comment+"\n") {
+		t.Errorf("Comment not passed as one argument:\n%s", args)
+	}
+
+	_, err = Render("doc", Options{
+		Command:       command,
+		OutputComment: strings.Repeat("x", 256),
+	})
+	if err == nil {
+		t.Error("Should reject an overlong comment")
+	}
+}
+
+func TestStopOnOscillation(t *testing.T) {
+	// This engine alternates between two .aux files and always asks for a
+	// rerun, so it never converges.
+	var script = `echo run >> $STATE/runs
+if [ -f odd ]; then rm odd; echo B > gotex.aux; else touch odd; echo A > gotex.aux; fi
+echo "Label(s) may have changed. Rerun to get cross-references right." > gotex.log
+echo "%PDF-1.5" > gotex.pdf
+`
+	var command, state = fakeLatex(t, script)
+	var buf bytes.Buffer
+	var _, err = Render("doc", Options{
+		Command:           command,
+		StopOnOscillation: true,
+		Logger:            log.New(&buf, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := countRuns(t, state); runs != 3 {
+		t.Error("Should stop when the .aux repeats, ran", runs)
+	}
+	if !strings.Contains(buf.String(), "oscillating") {
+		t.Errorf("Should warn about oscillation:\n%s", buf.String())
+	}
+
+	command, state = fakeLatex(t, script)
+	if _, err = Render("doc", Options{Command: command}); err != nil {
+		t.Fatal(err)
+	}
+	if runs := countRuns(t, state); runs != 5 {
+		t.Error("Should run the maximum of 5 times, ran", runs)
+	}
+}
+
+func TestShellEscape(t *testing.T) {
+	var command, state = fakeLatex(t, `echo "$*" > $STATE/args
+echo "%PDF-1.5" > gotex.pdf`)
+	var args = func() string {
+		var data, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+		return string(data)
+	}
+
+	if _, err := Render("doc", Options{Command: command, NoShellEscape: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(args(), " -no-shell-escape") {
+		t.Error("Missing -no-shell-escape:", args())
+	}
+	if _, err := Render("doc", Options{Command: command, ShellEscape: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(args(), " -shell-escape") || strings.Contains(args(), "-no-shell") {
+		t.Error("Wrong shell escape flags:", args())
+	}
+
+	var _, err = Render("doc", Options{
+		Command:       command,
+		ShellEscape:   true,
+		NoShellEscape: true,
+	})
+	if err == nil {
+		t.Error("Should reject ShellEscape with NoShellEscape")
+	}
+}
+
+func TestDetermineRuns(t *testing.T) {
+	// This engine asks for one rerun.
+	var command, _ = fakeLatex(t, `if [ -f gotex.aux ]; then echo > gotex.log
+else echo "Rerun to get cross-references right." > gotex.log; fi
+touch gotex.aux
+echo "%PDF-1.5" > gotex.pdf`)
+	var result Result
+	var runs, err = DetermineRuns("doc", Options{Command: command, Runs: 1, Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Error("Expected 2 runs, got", runs)
+	}
+	if result.Runs != 2 {
+		t.Error("The caller's Result should be filled in, got", result.Runs)
+	}
+
+	requireLatex(t, "pdflatex")
+	runs, err = DetermineRuns(`\documentclass{article}
+\begin{document}
+\section{Intro}\label{intro}
+See section~\ref{intro}.
+\end{document}
+`, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Error("Cross-references should need 2 runs, got", runs)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc to read the niceness from")
+	}
+	// This engine records its niceness, the 19th field of its stat file.
+	var command, state = fakeLatex(t, `cut -d " " -f 19 /proc/$$/stat > $STATE/nice
+echo "%PDF-1.5" > gotex.pdf`)
+	if _, err := Render("doc", Options{Command: command, Priority: 10}); err != nil {
+		t.Fatal(err)
+	}
+	var nice, _ = ioutil.ReadFile(filepath.Join(state, "nice"))
+	if string(nice) != "10\n" {
+		t.Errorf("Niceness should be 10, was %q", nice)
+	}
+}
+
+func TestFontRetries(t *testing.T) {
+	// This engine fails generating a font the first time only, because
+	// another render holds the lock on the font cache.
+	var script = `echo run >> $STATE/runs
+if [ ! -f $STATE/fonts ]; then
+	touch $STATE/fonts
+	echo "kpathsea: Running mktexpk --mfmode / --bdpi 600 --mag 1+0/600 --dpi 600 ecrm1000" > gotex.log
+	echo "mkdir: cannot create directory '/var/lib/texmf/fonts/pk/ljfour/jknappen/ec': File exists" >> gotex.log
+	echo "! Font T1/cmr/m/n/10=ecrm1000 at 10.0pt not loadable: Metric (TFM) file not found." >> gotex.log
+	exit 1
+fi
+echo > gotex.log
+echo "%PDF-1.5" > gotex.pdf`
+	var command, state = fakeLatex(t, script)
+	var result Result
+	var _, err = Render("doc", Options{
+		Command:        command,
+		FontRetries:    2,
+		FontRetryDelay: time.Millisecond,
+		Result:         &result,
+	})
+	if err != nil {
+		t.Fatal("Should succeed on retry:", err)
+	}
+	if runs := countRuns(t, state); runs != 2 {
+		t.Error("Expected 2 runs, got", runs)
+	}
+	if result.Runs != 2 || result.FontRetries != 1 {
+		t.Errorf("Retries should be counted, got %d runs, %d retries", result.Runs, result.FontRetries)
+	}
+
+	// DetermineRuns doesn't count the retries.
+	command, _ = fakeLatex(t, script)
+	runs, err := DetermineRuns("doc", Options{
+		Command:        command,
+		FontRetries:    2,
+		FontRetryDelay: time.Millisecond,
+	})
+	if err != nil || runs != 1 {
+		t.Error("Expected 1 run, got", runs, err)
+	}
+
+	// Without retries, the failure is returned.
+	command, _ = fakeLatex(t, script)
+	if _, err = Render("doc", Options{Command: command}); err == nil {
+		t.Error("Should fail without retries")
+	}
+
+	// Fonts that can't be generated at all are not retried.
+	command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "kpathsea: Running mktextfm nosuchfont" > gotex.log
+echo "mktextfm: Running mf-nowin -progname=mf \\mode:=ljfour; mag:=1; nonstopmode; input nosuchfont" >> gotex.log
+echo "! I can't find file 'nosuchfont'." >> gotex.log
+exit 1`)
+	_, err = Render("doc", Options{Command: command, FontRetries: 2, FontRetryDelay: time.Millisecond})
+	if err == nil || countRuns(t, state) != 1 {
+		t.Error("Should not retry missing fonts")
+	}
+
+	// Other errors are not retried.
+	command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "! Undefined control sequence." > gotex.log
+exit 1`)
+	_, err = Render("doc", Options{Command: command, FontRetries: 2})
+	if err == nil || countRuns(t, state) != 1 {
+		t.Error("Should not retry other errors")
+	}
+}
+
+func TestFailOnNonConvergence(t *testing.T) {
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "Rerun to get cross-references right." > gotex.log
+echo "%PDF-1.5" > gotex.pdf`)
+	if _, err := Render("doc", Options{Command: command}); err != nil {
+		t.Fatal("Should return the PDF by default:", err)
+	}
+	if runs := countRuns(t, state); runs != 5 {
+		t.Error("Expected 5 runs, got", runs)
+	}
+
+	var tmp = t.TempDir()
+	var _, err = Render("doc", Options{Command: command, FailOnNonConvergence: true, TempDir: tmp})
+	if !errors.Is(err, ErrNotConverged) {
+		t.Error("Expected ErrNotConverged, got", err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directory should be removed, found", len(files))
+	}
+
+	// An explicit number of runs is never an error.
+	_, err = Render("doc", Options{Command: command, Runs: 2, FailOnNonConvergence: true})
+	if err != nil {
+		t.Error("Explicit runs should not fail:", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestLogWriterFailure(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "This is pdfTeX" > gotex.log
+echo "%PDF-1.5" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var _, err = Render("doc", Options{Command: command, Log: failingWriter{}, TempDir: tmp})
+	if err == nil || err.Error() != "disk full" {
+		t.Error("Expected the writer's error, got", err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directory should be removed, found", len(files))
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// memoryParams lists the texmf.cnf parameters that Options.Memory may set.
+var memoryParams = map[string]bool{
+	"main_memory":      true,
+	"extra_mem_top":    true,
+	"extra_mem_bot":    true,
+	"font_mem_size":    true,
+	"font_max":         true,
+	"pool_size":        true,
+	"string_vacancies": true,
+	"max_strings":      true,
+	"hash_extra":       true,
+	"save_size":        true,
+	"stack_size":       true,
+	"buf_size":         true,
+	"nest_size":        true,
+	"param_size":       true,
+	"max_in_open":      true,
+	"expand_depth":     true,
+	"trie_size":        true,
+	"hyph_size":        true,
+	"dvi_buf_size":     true,
+}
+
+// checkMemory rejects unknown or negative memory parameters.
+func checkMemory(options Options) error {
+	for name, value := range options.Memory {
+		if !memoryParams[name] {
+			return fmt.Errorf("gotex: unknown memory parameter %q", name)
+		}
+		if value < 0 {
+			return fmt.Errorf("gotex: negative value for memory parameter %s", name)
+		}
+	}
+	return nil
+}
+
+// memoryEnv returns the environment variables that apply the Memory option
+// for the configured engine.
+func memoryEnv(options Options) []string {
+	if len(options.Memory) == 0 {
+		return nil
+	}
+	// LuaTeX has no fixed memory limits; compile logs that they're ignored.
+	if engine(options) == "lualatex" {
+		return nil
+	}
+	var env []string
+	for name, value := range options.Memory {
+		env = append(env, name+"="+strconv.Itoa(value))
+	}
+	// Keep the environment stable regardless of map order.
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	var command, state = fakeLatex(t, `echo "$extra_mem_top $pool_size" > $STATE/env
+echo "%PDF-1.5" > gotex.pdf`)
+	var memory = map[string]int{"extra_mem_top": 10000000, "pool_size": 6250000}
+	var _, err = Render("doc", Options{Command: command, Memory: memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env, _ = ioutil.ReadFile(filepath.Join(state, "env"))
+	if string(env) != "10000000 6250000\n" {
+		t.Errorf("Memory not applied: %q", env)
+	}
+
+	_, err = Render("doc", Options{
+		Command: command,
+		Memory:  map[string]int{"PATH": 1},
+	})
+	if err == nil {
+		t.Error("Should reject unknown memory parameters")
+	}
+
+	// LuaTeX has no fixed memory limits, so nothing is set.
+	var lualatex = filepath.Join(state, "lualatex")
+	if err = os.Rename(command, lualatex); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = Render("doc", Options{Command: lualatex, Memory: memory, Runs: 3, Logger: log.New(&buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, _ = ioutil.ReadFile(filepath.Join(state, "env"))
+	if string(env) != " \n" {
+		t.Errorf("Memory should be ignored for lualatex: %q", env)
+	}
+	if n := strings.Count(buf.String(), "ignoring Memory"); n != 1 {
+		t.Errorf("Expected one warning per render, got %d:\n%s", n, buf.String())
+	}
+}
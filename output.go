@@ -0,0 +1,257 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RenderToFile is like Render, but writes the PDF to outFilename instead of
+// returning it, without holding the whole PDF in memory.
+//
+// The PDF is first written to a temporary file next to outFilename and then
+// renamed into place, so readers never see a partially written file. If
+// several renders write to the same outFilename concurrently, the last one to
+// finish wins, but the file is never corrupted.
+func RenderToFile(document string, outFilename string, options Options) error {
+	return renderToFile(document, outFilename, options, nil)
+}
+
+// RenderToReader is like Render, but returns a reader over the PDF instead
+// of reading the whole PDF into memory. The PDF stays in the temporary
+// directory until the returned reader is closed, which removes it, so the
+// caller must always close the reader.
+func RenderToReader(document string, options Options) (r io.ReadCloser, err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	dir, err := compile(document, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path.Join(dir, "gotex.pdf"))
+	if err != nil {
+		removeDir(dir, options)
+		return nil, err
+	}
+	return &pdfReader{File: file, dir: dir, options: options}, nil
+}
+
+// pdfReader reads a PDF and removes its temporary directory when closed, or
+// releases it if it is a fixed TempName directory.
+type pdfReader struct {
+	*os.File
+	dir     string
+	options Options
+}
+
+// Close closes the PDF and removes or releases its temporary directory.
+func (r *pdfReader) Close() error {
+	var err = r.File.Close()
+	removeDir(r.dir, r.options)
+	return err
+}
+
+// RenderToSink is like RenderToReader, but passes the reader over the PDF to
+// sink, such as a function that emails the PDF or uploads it to object
+// storage, and removes the temporary directory once sink returns. The error
+// from sink, if any, is returned.
+func RenderToSink(document string, sink func(r io.Reader) error, options Options) (err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	dir, err := compile(document, options, nil)
+	if err != nil {
+		return err
+	}
+	defer removeDir(dir, options)
+	file, err := os.Open(path.Join(dir, "gotex.pdf"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return sink(file)
+}
+
+// RenderTo is like Render, but streams the PDF to w instead of returning it.
+// The PDF is copied from the temporary directory in small chunks, so w can be
+// a pipe or a network upload of any size without the PDF being held in
+// memory. Nothing is written to w unless the document compiles, so a failed
+// render leaves w untouched, e.g. to send an error response instead; but if
+// w fails partway through, part of the PDF has been written.
+func RenderTo(document string, w io.Writer, options Options) error {
+	return RenderToSink(document, func(r io.Reader) error {
+		var _, err = io.Copy(w, r)
+		return err
+	}, options)
+}
+
+// RenderWithArtifacts is like Render, but also returns the other files LaTeX
+// produced that match Options.Artifacts, such as .synctex.gz, .bbl, or .log
+// files. The files are keyed by their path relative to the temporary
+// directory; the PDF is always included, as "gotex.pdf".
+func RenderWithArtifacts(document string, options Options) (files map[string][]byte, err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	dir, err := compile(document, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Clean up the temp directory.
+	defer removeDir(dir, options)
+	files = map[string][]byte{}
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		var rel, _ = filepath.Rel(dir, name)
+		if rel == tempDirMarker || (rel != "gotex.pdf" && !matchAny(options.Artifacts, rel)) {
+			return nil
+		}
+		files[rel], err = ioutil.ReadFile(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// matchAny reports whether name matches any of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderParts renders a document given as a separate preamble and body to
+// outFilename, like RenderToFile. This suits callers that keep a fixed
+// preamble and vary only the body; use Pool.RenderParts to also reuse a
+// precompiled format for the preamble.
+//
+// The body may be either bare content, in which case it is wrapped in
+// \begin{document} and \end{document}, or already contain \begin{document},
+// in which case it is used as is. The preamble must not contain
+// \begin{document}.
+func RenderParts(preamble, body string, outFilename string, options Options) error {
+	var document, err = joinParts(preamble, body)
+	if err != nil {
+		return err
+	}
+	return renderToFile(document, outFilename, options, nil)
+}
+
+// RenderBatch renders one PDF per body, all sharing the same preamble, like
+// RenderParts. Body i is written to fmt.Sprintf(outPattern, i), e.g.
+// "invoice-%03d.pdf". The preamble is precompiled into a format once and
+// reused for every body, which makes this the fastest way to render many
+// similar documents. It stops at the first body that fails to render.
+func RenderBatch(preamble string, bodies []string, outPattern string, options Options) error {
+	var formats = &formatCache{}
+	defer formats.close()
+	for i, body := range bodies {
+		var document, err = joinParts(preamble, body)
+		if err != nil {
+			return err
+		}
+		err = renderToFile(document, fmt.Sprintf(outPattern, i), options, formats)
+		if err != nil {
+			return fmt.Errorf("gotex: body %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// joinParts combines a preamble and body into a complete document.
+func joinParts(preamble, body string) (string, error) {
+	if strings.Contains(preamble, `\begin{document}`) {
+		return "", errors.New(`gotex: preamble contains \begin{document}`)
+	}
+	if !strings.HasSuffix(preamble, "\n") {
+		preamble += "\n"
+	}
+	if !strings.Contains(body, `\begin{document}`) {
+		body = "\\begin{document}\n" + body + "\n\\end{document}\n"
+	}
+	return preamble + body, nil
+}
+
+// renderToFile does the work of RenderToFile, using formats like render.
+func renderToFile(document string, outFilename string, options Options, formats *formatCache) (err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	dir, err := compile(document, options, formats)
+	if err != nil {
+		return err
+	}
+	// Clean up the temp directory.
+	defer removeDir(dir, options)
+	return moveFile(path.Join(dir, "gotex.pdf"), outFilename, options.Durable)
+}
+
+// syncFile flushes a file to disk. It's a variable so tests can observe it.
+var syncFile = func(f *os.File) error {
+	return f.Sync()
+}
+
+// moveFile atomically replaces dst with the contents of src. The data is
+// copied to a temporary file in dst's directory first, since src may be on a
+// different filesystem, where rename isn't possible. If durable is true, the
+// file and then the directory are synced, so the rename is on disk too.
+func moveFile(src, dst string, durable bool) error {
+	var in, err = os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// Remove the temporary file unless it was successfully renamed.
+	defer os.Remove(out.Name())
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if durable {
+		if err = syncFile(out); err != nil {
+			_ = out.Close()
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	// TempFile creates files readable only by the owner.
+	if err = os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(out.Name(), dst); err != nil {
+		return err
+	}
+	if !durable {
+		return nil
+	}
+	parent, err := os.Open(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	return syncFile(parent)
+}
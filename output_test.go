@@ -0,0 +1,312 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRenderToFileConcurrent(t *testing.T) {
+	// This engine writes a large PDF made of the document's text repeated.
+	var command, _ = fakeLatex(t, `yes "$(cat)" | head -c 2000000 > gotex.pdf`)
+	var dir = t.TempDir()
+	var out = filepath.Join(dir, "out.pdf")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var document = string(rune('a' + i%2))
+			if err := RenderToFile(document, out, Options{Command: command}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var data, err = ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2000000 {
+		t.Fatal("Output was truncated to", len(data))
+	}
+	// The whole file must come from a single render.
+	var line = string(data[:2])
+	if strings.Count(string(data), line) != len(data)/2 {
+		t.Error("Output mixes several renders")
+	}
+
+	// No temporary files should be left next to the output.
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("Expected only the output file, found", len(files))
+	}
+}
+
+func TestRenderToFileFailureListsDir(t *testing.T) {
+	var command, _ = fakeLatex(t, `printf "! Undefined control sequence.\n" > gotex.log
+mkdir figures && printf "1234" > figures/plot.pdf
+exit 1
+`)
+	var buf bytes.Buffer
+	var err = RenderToFile("doc", filepath.Join(t.TempDir(), "out.pdf"),
+		Options{Command: command, Logger: log.New(&buf, "", 0)})
+	if err == nil {
+		t.Fatal("Render should fail")
+	}
+	for _, want := range []string{
+		"gotex: contents of ",
+		"gotex:   gotex.log (30 bytes)",
+		"gotex:   figures/plot.pdf (4 bytes)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Log is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPostValidate(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var out = filepath.Join(t.TempDir(), "out.pdf")
+
+	// The validator gets the PDF's path as its last argument, which sh -c
+	// puts in $0.
+	var err = RenderToFile("doc", out, Options{
+		Command:      command,
+		PostValidate: []string{"sh", "-c", `echo "xref table broken in $(basename $0)"; exit 2`},
+	})
+	if err == nil || !strings.Contains(err.Error(), "xref table broken in gotex.pdf") {
+		t.Fatal("Failing validator should fail the render, got", err)
+	}
+	// The PDF is kept for inspection, and the error says where.
+	var m = regexp.MustCompile(`validation of (\S+) with`).FindStringSubmatch(err.Error())
+	if m == nil {
+		t.Fatal("Error should name the PDF:", err)
+	}
+	if _, statErr := os.Stat(m[1]); statErr != nil {
+		t.Error("Invalid PDF should be kept:", statErr)
+	}
+	_ = os.RemoveAll(filepath.Dir(m[1]))
+	if _, readErr := ioutil.ReadFile(out); readErr == nil {
+		t.Error("Invalid PDF should not be written")
+	}
+
+	err = RenderToFile("doc", out, Options{
+		Command:      command,
+		PostValidate: []string{"sh", "-c", `head -c 5 "$0" | grep -q %PDF-`},
+	})
+	if err != nil {
+		t.Error("Passing validator should not fail the render:", err)
+	}
+}
+
+func TestRenderParts(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	var out = filepath.Join(t.TempDir(), "out.pdf")
+	var preamble = "\\documentclass{article}\n\\usepackage{xcolor}"
+	var want = "\\documentclass{article}\n\\usepackage{xcolor}\n" +
+		"\\begin{document}\nHello.\n\\end{document}\n"
+
+	for _, body := range []string{
+		"Hello.",
+		"\\begin{document}\nHello.\n\\end{document}\n",
+	} {
+		var err = RenderParts(preamble, body, out, Options{Command: command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+		if string(fed) != want {
+			t.Errorf("Wrong combined document:\n%s", fed)
+		}
+	}
+
+	var err = RenderParts(want, "Hello.", out, Options{Command: command})
+	if err == nil {
+		t.Error("Should reject a preamble containing \\begin{document}")
+	}
+}
+
+func TestDurable(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var dir = t.TempDir()
+	var out = filepath.Join(dir, "out.pdf")
+
+	var synced []string
+	var orig = syncFile
+	defer func() { syncFile = orig }()
+	syncFile = func(f *os.File) error {
+		synced = append(synced, f.Name())
+		return orig(f)
+	}
+
+	if err := RenderToFile("doc", out, Options{Command: command}); err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 0 {
+		t.Error("Should not sync by default", synced)
+	}
+
+	if err := RenderToFile("doc", out, Options{Command: command, Durable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 2 {
+		t.Fatal("Should sync the file and the directory", synced)
+	}
+	if !strings.HasPrefix(synced[0], filepath.Join(dir, ".out.pdf.tmp-")) {
+		t.Error("Should sync the output file first, synced", synced[0])
+	}
+	if synced[1] != dir {
+		t.Error("Should sync the output directory, synced", synced[1])
+	}
+}
+
+func TestRenderToReader(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5 reader" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var r, err = RenderToReader("doc", Options{Command: command, TempDir: tmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pdf) != "%PDF-1.5 reader\n" {
+		t.Errorf("Wrong PDF: %q", pdf)
+	}
+
+	// The temporary directory is kept until the reader is closed.
+	files, _ := ioutil.ReadDir(tmp)
+	if len(files) != 1 {
+		t.Fatal("Temporary directory should exist while reading")
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = ioutil.ReadDir(tmp)
+	if len(files) != 0 {
+		t.Error("Temporary directory should be removed on close")
+	}
+}
+
+func TestRenderToSink(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5 sink" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var pdf []byte
+	var err = RenderToSink("doc", func(r io.Reader) error {
+		// The temporary directory is kept while the sink runs.
+		if files, _ := ioutil.ReadDir(tmp); len(files) != 1 {
+			t.Error("Temporary directory should exist in the sink")
+		}
+		var err error
+		pdf, err = ioutil.ReadAll(r)
+		return err
+	}, Options{Command: command, TempDir: tmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pdf) != "%PDF-1.5 sink\n" {
+		t.Errorf("Wrong PDF: %q", pdf)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directory should be removed after the sink")
+	}
+
+	// Errors from the sink are returned, and the directory is still removed.
+	var sinkErr = errors.New("upload failed")
+	err = RenderToSink("doc", func(io.Reader) error { return sinkErr },
+		Options{Command: command, TempDir: tmp})
+	if err != sinkErr {
+		t.Error("Expected the sink's error, got", err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directory should be removed after a failed sink")
+	}
+}
+
+func TestRenderWithArtifacts(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf
+echo "This is pdfTeX" > gotex.log
+echo "\\relax" > gotex.aux
+mkdir ext && echo "%PDF-1.5 figure" > ext/figure0.pdf`)
+	var files, err = RenderWithArtifacts("doc", Options{
+		Command:   command,
+		Artifacts: []string{"*.log", "ext/*.pdf"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = map[string]string{
+		"gotex.pdf":       "%PDF-1.5\n",
+		"gotex.log":       "This is pdfTeX\n",
+		"ext/figure0.pdf": "%PDF-1.5 figure\n",
+	}
+	if len(files) != len(want) {
+		t.Error("Wrong files returned", len(files))
+	}
+	for name, content := range want {
+		if string(files[name]) != content {
+			t.Errorf("Wrong %s: %q", name, files[name])
+		}
+	}
+}
+
+func TestRenderTo(t *testing.T) {
+	// This engine writes a large PDF made of the document's text repeated.
+	var command, _ = fakeLatex(t, `yes "$(cat)" | head -c 20000000 > gotex.pdf`)
+	var r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var errs = make(chan error, 1)
+	go func() {
+		errs <- RenderTo("pdf", w, Options{Command: command})
+		w.Close()
+	}()
+	// Read the pipe in small pieces, so the writer must stream.
+	var n int
+	var buf = make([]byte, 4096)
+	for {
+		var m, err = r.Read(buf)
+		if m > 0 && string(buf[:3]) != "pdf" && n == 0 {
+			t.Errorf("Wrong start of PDF: %q", buf[:m])
+		}
+		n += m
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n != 20000000 {
+		t.Error("Expected 20000000 bytes, got", n)
+	}
+
+	// Nothing is written when the render fails.
+	command, _ = fakeLatex(t, `echo "%PDF-1.5 partial" > gotex.pdf; exit 1`)
+	var out bytes.Buffer
+	if err = RenderTo("doc", &out, Options{Command: command}); err == nil {
+		t.Error("Render should fail")
+	}
+	if out.Len() != 0 {
+		t.Errorf("Nothing should be written on failure, got %q", out.String())
+	}
+}
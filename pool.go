@@ -0,0 +1,70 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+// Pool renders documents with a bounded number of concurrent LaTeX processes.
+// Documents rendered through a Pool also share a precompiled format for their
+// preamble: the format is built lazily by the first render, reused by every
+// render with the same preamble, and rebuilt when the preamble changes. This
+// makes high-volume rendering of documents with a common preamble, such as
+// invoices from one template, considerably faster. If a preamble can't be
+// precompiled, documents are rendered normally.
+//
+// A Pool is safe for concurrent use. Call Close when done with it to remove
+// the cached format.
+type Pool struct {
+	options Options
+	slots   chan struct{}
+	formats *formatCache
+}
+
+// NewPool returns a Pool that runs at most size renders at once, each using
+// the given options. A size less than 1 is treated as 1.
+func NewPool(size int, options Options) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		options: options,
+		slots:   make(chan struct{}, size),
+		formats: &formatCache{},
+	}
+}
+
+// Render renders a document like the package-level Render, waiting for a free
+// slot if the pool is busy.
+func (p *Pool) Render(document string) ([]byte, error) {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+	return render(document, p.options, p.formats)
+}
+
+// RenderParts renders a document given as a separate preamble and body to
+// outFilename, like the package-level RenderParts, loading the preamble from
+// the pool's precompiled format.
+func (p *Pool) RenderParts(preamble, body string, outFilename string) error {
+	var document, err = joinParts(preamble, body)
+	if err != nil {
+		return err
+	}
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+	return renderToFile(document, outFilename, p.options, p.formats)
+}
+
+// WithLogger returns a Pool that logs to logger instead, for example to tag
+// messages with a request ID. It shares its slots and cached format with p,
+// so renders through either count against the same limit.
+func (p *Pool) WithLogger(logger Logger) *Pool {
+	var derived = *p
+	derived.options.Logger = logger
+	return &derived
+}
+
+// Close removes the cached format. The Pool may still be used afterwards, but
+// the format will be rebuilt.
+func (p *Pool) Close() error {
+	p.formats.close()
+	return nil
+}
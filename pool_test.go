@@ -0,0 +1,252 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// formatLatex is a fakeLatex script that dumps a format in -ini mode, counting
+// builds in $STATE/builds, and otherwise requires the format to be present.
+const formatLatex = `case "$*" in
+*-ini*)
+	echo build >> $STATE/builds
+	sleep 0.1
+	cat gotexfmt.tex > gotexfmt.fmt
+	;;
+*-fmt=gotexfmt*)
+	test -f gotexfmt.fmt || exit 1
+	cat > $STATE/body.tex
+	echo "%PDF-1.5" > gotex.pdf
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+func TestPoolFormat(t *testing.T) {
+	var command, state = fakeLatex(t, formatLatex)
+	var pool = NewPool(4, Options{Command: command})
+	defer pool.Close()
+
+	var document = `\documentclass{article}
+\usepackage{graphicx}
+\begin{document}
+Invoice.
+\end{document}
+`
+	// Many concurrent first renders should build the format only once.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Render(document); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 1 {
+		t.Error("Format should be built once, was built", n, "times")
+	}
+	var body, _ = ioutil.ReadFile(filepath.Join(state, "body.tex"))
+	if !strings.HasPrefix(string(body), `\begin{document}`) {
+		t.Errorf("Only the body should be compiled:\n%s", body)
+	}
+
+	// A different preamble invalidates the format.
+	_, err := pool.Render(strings.Replace(document, "graphicx", "xcolor", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 2 {
+		t.Error("Format should be rebuilt, was built", n, "times")
+	}
+}
+
+func TestPoolWithLogger(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var pool = NewPool(2, Options{Command: command})
+	defer pool.Close()
+
+	var bufs [2]bytes.Buffer
+	var wg sync.WaitGroup
+	for i := range bufs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var logger = log.New(&bufs[i], "", 0)
+			if _, err := pool.WithLogger(logger).Render("doc"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Each render logs one run, naming its own temporary directory.
+	for i := range bufs {
+		if n := strings.Count(bufs[i].String(), "gotex: run 1"); n != 1 {
+			t.Errorf("Logger %d should see one run, saw %d:\n%s", i, n, bufs[i].String())
+		}
+	}
+	if bufs[0].String() == bufs[1].String() {
+		t.Error("Renders should log to their own loggers")
+	}
+}
+
+func TestPoolRenderParts(t *testing.T) {
+	var command, state = fakeLatex(t, formatLatex)
+	var pool = NewPool(1, Options{Command: command})
+	defer pool.Close()
+
+	var out = filepath.Join(t.TempDir(), "out.pdf")
+	for _, body := range []string{"One.", "Two."} {
+		var err = pool.RenderParts("\\documentclass{article}\n", body, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fed, _ = ioutil.ReadFile(filepath.Join(state, "body.tex"))
+		if string(fed) != "\\begin{document}\n"+body+"\n\\end{document}\n" {
+			t.Errorf("Wrong body compiled:\n%s", fed)
+		}
+	}
+	var builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 1 {
+		t.Error("Preamble should be precompiled once, was built", n, "times")
+	}
+}
+
+func TestRenderBatch(t *testing.T) {
+	// Like formatLatex, but the PDF contains the body.
+	var command, state = fakeLatex(t, strings.Replace(formatLatex,
+		`echo "%PDF-1.5" > gotex.pdf`, `cp $STATE/body.tex gotex.pdf`, 1))
+	var dir = t.TempDir()
+	var bodies = []string{"Alice", "Bob", "Carol"}
+	var err = RenderBatch("\\documentclass{article}\n", bodies,
+		filepath.Join(dir, "invoice-%d.pdf"), Options{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range bodies {
+		var pdf, err = ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("invoice-%d.pdf", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(pdf), body) {
+			t.Errorf("invoice-%d.pdf has the wrong body: %s", i, pdf)
+		}
+	}
+	var builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 1 {
+		t.Error("Preamble should be precompiled once, was built", n, "times")
+	}
+}
+
+func TestPoolEngine(t *testing.T) {
+	// Put fake lualatex and xelatex first in $PATH, to see which is selected.
+	var command, state = fakeLatex(t, "basename $0 >> $STATE/engines\n"+formatLatex)
+	for _, name := range []string{"lualatex", "xelatex"} {
+		if err := os.Symlink(command, filepath.Join(state, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", state+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var tests = []struct {
+		options  Options
+		document string
+		engine   string
+	}{
+		{Options{Tagged: true}, "\\documentclass{article}\n\\begin{document}\n\\end{document}\n", "lualatex"},
+		{Options{}, "\\documentclass{article}\n\\usepackage{bidi}\n\\begin{document}\n\\end{document}\n", "xelatex"},
+	}
+	for _, test := range tests {
+		_ = os.Remove(filepath.Join(state, "engines"))
+		var buf bytes.Buffer
+		test.options.Logger = log.New(&buf, "", 0)
+		var pool = NewPool(1, test.options)
+		if _, err := pool.Render(test.document); err != nil {
+			t.Fatal(err)
+		}
+		pool.Close()
+		var engines, _ = ioutil.ReadFile(filepath.Join(state, "engines"))
+		if string(engines) != test.engine+"\n"+test.engine+"\n" {
+			t.Errorf("Expected %s for the format and render, got:\n%s", test.engine, engines)
+		}
+		if strings.Contains(buf.String(), "will likely fail") {
+			t.Error("Unexpected engine warning:", buf.String())
+		}
+	}
+}
+
+func TestPoolFormatFailure(t *testing.T) {
+	// This engine can't dump formats, but compiles documents normally.
+	var command, state = fakeLatex(t, `case "$*" in
+*-ini*)
+	echo build >> $STATE/builds
+	echo "! LaTeX Error: File 'missing.sty' not found." > gotexfmt.log
+	exit 1
+	;;
+esac
+cat > /dev/null
+echo "%PDF-1.5" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var buf bytes.Buffer
+	var pool = NewPool(2, Options{Command: command, TempDir: tmp, Logger: log.New(&buf, "", 0)})
+	defer pool.Close()
+
+	var document = "\\documentclass{article}\n\\usepackage{missing}\n\\begin{document}\n\\end{document}\n"
+	for i := 0; i < 2; i++ {
+		if _, err := pool.Render(document); err != nil {
+			t.Fatal("Should fall back to compiling normally:", err)
+		}
+	}
+	if !strings.Contains(buf.String(), "precompiling the preamble failed: LaTeX Error: File 'missing.sty' not found.") {
+		t.Error("Expected the failure to be logged, got", buf.String())
+	}
+	var builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 1 {
+		t.Error("Failed build should not be retried, was built", n, "times")
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Failed build should not leave directories behind, found", len(files))
+	}
+}
+
+func TestFormatClassFiles(t *testing.T) {
+	// This engine puts the class file into the format, so it must be there.
+	var command, state = fakeLatex(t, `echo build >> $STATE/builds
+cat gotexfmt.tex corp.cls > gotexfmt.fmt`)
+	var cache formatCache
+	defer cache.close()
+
+	var document = "\\documentclass{corp}\n\\begin{document}\n\\end{document}\n"
+	for i, class := range []string{"% v1", "% v1", "% v2"} {
+		var dir = t.TempDir()
+		var options = Options{Command: command, ClassFiles: map[string][]byte{"corp.cls": []byte(class)}}
+		if _, ok := cache.use(document, options, dir); !ok {
+			t.Fatal("Format should be built with the class file")
+		}
+		var data, _ = ioutil.ReadFile(filepath.Join(dir, formatName+".fmt"))
+		if !strings.HasSuffix(string(data), class) {
+			t.Errorf("Render %d got the wrong format: %q", i, data)
+		}
+	}
+	var builds, _ = ioutil.ReadFile(filepath.Join(state, "builds"))
+	if n := strings.Count(string(builds), "\n"); n != 2 {
+		t.Error("Changing the class file should rebuild the format, was built", n, "times")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// minFreeSpace is how much free space the temporary directory needs before
+// gotex will attempt a compile. LaTeX, its font caches, and the output
+// usually fit comfortably within this.
+const minFreeSpace = 16 << 20
+
+// makeTempDir creates the temporary directory where LaTeX will run and checks
+// that it is actually usable. A full or read-only $TMPDIR otherwise shows up
+// as a cryptic failure deep in the LaTeX log.
+func makeTempDir(options Options) (string, error) {
+	var dir, err = ioutil.TempDir(options.TempDir, tempPrefix(options))
+	if err != nil {
+		return "", fmt.Errorf("gotex: temp dir not writable, set "+
+			"Options.TempDir to a writable directory: %v", err)
+	}
+	if err = checkTempDir(dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// tempDirMarker is the file gotex puts in a fixed TempName directory, so
+// that it only ever empties directories it made itself.
+const tempDirMarker = ".gotex"
+
+// namedTempDirs holds a semaphore for each fixed TempName directory, so that
+// renders sharing one don't overwrite each other's files.
+var namedTempDirs = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: map[string]chan struct{}{}}
+
+// namedTempDir returns the fixed directory selected by Options.TempName.
+func namedTempDir(options Options) (string, error) {
+	var name = options.TempName
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("gotex: invalid temp dir name %q", name)
+	}
+	var base = options.TempDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	return filepath.Abs(filepath.Join(base, name))
+}
+
+// namedTempDirLock returns the semaphore of a fixed TempName directory.
+func namedTempDirLock(dir string) chan struct{} {
+	namedTempDirs.Lock()
+	defer namedTempDirs.Unlock()
+	var lock = namedTempDirs.m[dir]
+	if lock == nil {
+		lock = make(chan struct{}, 1)
+		namedTempDirs.m[dir] = lock
+	}
+	return lock
+}
+
+// claimTempName waits until no other render in this process is using the
+// fixed TempName directory and claims it. The directory stays claimed until
+// releaseTempName is called, which removeDir does.
+func claimTempName(options Options) error {
+	var dir, err = namedTempDir(options)
+	if err != nil {
+		return err
+	}
+	namedTempDirLock(dir) <- struct{}{}
+	return nil
+}
+
+// releaseTempName releases the fixed TempName directory claimed by
+// claimTempName. Releasing a directory that isn't claimed does nothing.
+func releaseTempName(options Options) {
+	var dir, err = namedTempDir(options)
+	if err != nil {
+		return
+	}
+	select {
+	case <-namedTempDirLock(dir):
+	default:
+	}
+}
+
+// makeNamedTempDir empties or creates the fixed TempName directory and
+// checks that it is usable, like makeTempDir. An existing directory is only
+// emptied if gotex made it, as shown by its marker file, so that a TempName
+// that happens to match an existing directory doesn't destroy it.
+func makeNamedTempDir(options Options) (string, error) {
+	var dir, err = namedTempDir(options)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(dir); err == nil {
+		if _, err = os.Stat(filepath.Join(dir, tempDirMarker)); err != nil {
+			return "", fmt.Errorf("gotex: %s already exists and wasn't made by "+
+				"gotex, refusing to empty it; choose another TempName", dir)
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return "", err
+		}
+	}
+	if err = os.Mkdir(dir, 0700); err != nil {
+		return "", fmt.Errorf("gotex: temp dir not writable, set "+
+			"Options.TempDir to a writable directory: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, tempDirMarker), nil, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err = checkTempDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// tempDirs counts the temporary directories made by this process.
+var tempDirs uint64
+
+// tempPrefix returns the prefix for a new temporary directory, such as
+// "gotex-invoice-42-7-". It includes Options.TempPrefix, if set, and a
+// counter, so that the directories of concurrent renders can be told apart.
+func tempPrefix(options Options) string {
+	var prefix = "gotex-"
+	if options.TempPrefix != "" {
+		prefix += strings.NewReplacer("/", "_", string(filepath.Separator), "_").
+			Replace(options.TempPrefix) + "-"
+	}
+	return prefix + strconv.FormatUint(atomic.AddUint64(&tempDirs, 1), 10) + "-"
+}
+
+// checkTempDir verifies that a file can be written to dir and that the
+// filesystem has enough free space.
+func checkTempDir(dir string) error {
+	var probe = path.Join(dir, "gotex.probe")
+	var err = ioutil.WriteFile(probe, []byte("gotex"), 0600)
+	if err != nil {
+		return fmt.Errorf("gotex: temp dir %s not writable, set "+
+			"Options.TempDir to a writable directory: %v", dir, err)
+	}
+	_ = os.Remove(probe)
+
+	free, ok := freeSpace(dir)
+	if ok && free < minFreeSpace {
+		return fmt.Errorf("gotex: insufficient space in temp dir %s: "+
+			"%d bytes free, need at least %d", dir, free, minFreeSpace)
+	}
+	return nil
+}
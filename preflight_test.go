@@ -0,0 +1,211 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTempDirNotWritable(t *testing.T) {
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs`)
+
+	// A path that is not a directory at all.
+	var file = filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var _, err = Render("doc", Options{Command: command, TempDir: file})
+	if err == nil || !strings.Contains(err.Error(), "temp dir not writable") {
+		t.Error("Should fail with a clear error, got", err)
+	}
+
+	// A read-only directory. Permissions don't apply to root.
+	if os.Geteuid() != 0 {
+		var dir = t.TempDir()
+		if err = os.Chmod(dir, 0555); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(dir, 0755)
+		_, err = Render("doc", Options{Command: command, TempDir: dir})
+		if err == nil || !strings.Contains(err.Error(), "not writable") {
+			t.Error("Should fail with a clear error, got", err)
+		}
+	}
+
+	if runs := countRuns(t, state); runs != 0 {
+		t.Error("Should not attempt to compile, ran", runs)
+	}
+}
+
+func TestCheckTempDir(t *testing.T) {
+	if err := checkTempDir(t.TempDir()); err != nil {
+		t.Error("Writable temp dir should pass:", err)
+	}
+}
+
+func TestTempName(t *testing.T) {
+	// Each run records its directory and leaves a file behind; the file of
+	// the previous render must be gone.
+	var command, state = fakeLatex(t, `pwd >> $STATE/dirs
+ls > $STATE/files
+touch leftover
+sleep 0.1
+echo "%PDF-1.5" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var options = Options{Command: command, TempDir: tmp, TempName: "gotex-debug"}
+	for i := 0; i < 2; i++ {
+		if _, err := Render("doc", options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var dir = filepath.Join(tmp, "gotex-debug")
+	var dirs, _ = ioutil.ReadFile(filepath.Join(state, "dirs"))
+	if string(dirs) != dir+"\n"+dir+"\n" {
+		t.Errorf("Expected both renders in %s, got:\n%s", dir, dirs)
+	}
+	var files, _ = ioutil.ReadFile(filepath.Join(state, "files"))
+	if strings.Contains(string(files), "leftover") {
+		t.Error("Directory should be emptied before each render")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gotex.pdf")); err != nil {
+		t.Error("Directory should be kept after the render:", err)
+	}
+
+	// Concurrent renders take turns, so each finds the directory empty.
+	var errs = make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			var _, err = Render("doc", options)
+			if err == nil {
+				files, _ := ioutil.ReadFile(filepath.Join(state, "files"))
+				if strings.Contains(string(files), "leftover") {
+					err = errors.New("renders overlapped")
+				}
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	options.TempName = "../escape"
+	if _, err := Render("doc", options); err == nil {
+		t.Error("Should reject a name that isn't a plain file name")
+	}
+}
+
+func TestTempPrefix(t *testing.T) {
+	var command, state = fakeLatex(t, `basename "$PWD" >> $STATE/dirs
+sleep 0.1
+echo "%PDF-1.5" > gotex.pdf`)
+	var errs = make(chan error)
+	for _, id := range []string{"req-1", "req/2", ""} {
+		go func(id string) {
+			var _, err = Render("doc", Options{Command: command, TempPrefix: id})
+			errs <- err
+		}(id)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var data, _ = ioutil.ReadFile(filepath.Join(state, "dirs"))
+	var dirs = strings.Fields(string(data))
+	var found = map[string]bool{}
+	var counters = map[string]bool{}
+	for _, dir := range dirs {
+		var parts = strings.Split(strings.TrimPrefix(dir, "gotex-"), "-")
+		switch {
+		case strings.HasPrefix(dir, "gotex-req-1-"):
+			found["req-1"] = true
+			parts = parts[2:]
+		case strings.HasPrefix(dir, "gotex-req_2-"):
+			found["req/2"] = true
+			parts = parts[1:]
+		default:
+			found[""] = true
+		}
+		// What's left is the counter and the random suffix.
+		if len(parts) != 2 {
+			t.Fatalf("Unexpected directory name %s", dir)
+		}
+		counters[parts[0]] = true
+	}
+	if len(found) != 3 {
+		t.Errorf("Expected a directory for each prefix, got %q", dirs)
+	}
+	if len(counters) != 3 {
+		t.Errorf("Expected distinct counters, got %q", dirs)
+	}
+}
+
+func TestTempNameExisting(t *testing.T) {
+	var command, state = fakeLatex(t, `echo run >> $STATE/runs
+echo "%PDF-1.5" > gotex.pdf`)
+	var tmp = t.TempDir()
+	var precious = filepath.Join(tmp, "Documents", "thesis.tex")
+	if err := os.MkdirAll(filepath.Dir(precious), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(precious, []byte("precious"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var _, err = Render("doc", Options{Command: command, TempDir: tmp, TempName: "Documents"})
+	if err == nil || !strings.Contains(err.Error(), "refusing to empty it") {
+		t.Error("Should refuse a directory gotex didn't make, got", err)
+	}
+	if data, _ := ioutil.ReadFile(precious); string(data) != "precious" {
+		t.Error("Existing directory was emptied")
+	}
+	if runs := countRuns(t, state); runs != 0 {
+		t.Error("LaTeX should not run, ran", runs)
+	}
+
+	// The failed render released the name, so this one doesn't wait forever.
+	_, err = Render("doc", Options{Command: command, TempDir: tmp, TempName: "Documents"})
+	if err == nil {
+		t.Error("Should still refuse the directory")
+	}
+}
+
+func TestTempNameReader(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var options = Options{Command: command, TempDir: t.TempDir(), TempName: "gotex-debug"}
+	var r, err = RenderToReader("doc", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another render must wait until the reader is closed.
+	var done = make(chan error)
+	go func() {
+		var _, err = Render("doc", options)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		t.Fatal("Render should wait for the reader to be closed:", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if pdf, _ := ioutil.ReadAll(r); string(pdf) != "%PDF-1.5\n" {
+		t.Errorf("Wrong PDF: %q", pdf)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
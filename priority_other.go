@@ -0,0 +1,20 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package gotex
+
+import (
+	"os/exec"
+)
+
+// niceCommand returns the command to run name with args. Priority is not
+// implemented on this platform.
+func niceCommand(options Options, name string, args ...string) *exec.Cmd {
+	if options.Priority != 0 {
+		logf(options, "gotex: warning: Priority is not supported on this platform")
+	}
+	return exec.Command(name, args...)
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package gotex
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// niceCommand returns the command to run name with args at Options.Priority.
+// The command is run under nice(1), so the priority applies from the start
+// rather than being set once the process is already running.
+func niceCommand(options Options, name string, args ...string) *exec.Cmd {
+	if options.Priority == 0 {
+		return exec.Command(name, args...)
+	}
+	// Resolve the command here, so that a missing engine is still reported
+	// by Start instead of by nice.
+	var command, err = exec.LookPath(name)
+	if err != nil {
+		return exec.Command(name, args...)
+	}
+	nice, err := exec.LookPath("nice")
+	if err != nil {
+		logf(options, "gotex: warning: can't set priority to %d: %v", options.Priority, err)
+		return exec.Command(name, args...)
+	}
+	return exec.Command(nice,
+		append([]string{"-n", strconv.Itoa(options.Priority), command}, args...)...)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Resolve returns the absolute path of the file LaTeX would use for name,
+// such as "logo.png", given the options' asset directories. It runs
+// kpsewhich, the tool LaTeX itself uses to look up files, without compiling
+// anything. This helps when several asset directories contain a file with
+// the same name. If Options.Command is a path, the kpsewhich next to it is
+// used.
+func Resolve(name string, options Options) (string, error) {
+	if options.Command == "" {
+		options.Command = "pdflatex"
+	}
+	var kpsewhich = "kpsewhich"
+	if strings.ContainsRune(options.Command, filepath.Separator) {
+		kpsewhich = filepath.Join(filepath.Dir(options.Command), kpsewhich)
+	}
+
+	// Run in an empty directory, like LaTeX does, so that files in our own
+	// working directory aren't found.
+	var dir, err = makeTempDir(options)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	var cmd = exec.Command(kpsewhich, "-progname="+engine(options), "-format=tex", name)
+	cmd.Dir = dir
+	cmd.Env = latexEnv("", options)
+	output, err := cmd.Output()
+	var found = strings.TrimSpace(string(output))
+	if found == "" {
+		return "", fmt.Errorf("gotex: %s not found: %v", name, err)
+	}
+	return filepath.Abs(found)
+}
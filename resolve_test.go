@@ -0,0 +1,57 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	// This kpsewhich searches $TEXINPUTS in order.
+	var bin = t.TempDir()
+	var err = ioutil.WriteFile(filepath.Join(bin, "kpsewhich"), []byte(`#!/bin/sh
+for last; do :; done
+IFS=:
+for dir in $TEXINPUTS; do
+	if [ -n "$dir" ] && [ -f "$dir/$last" ]; then echo "$dir/$last"; exit 0; fi
+done
+exit 1
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second = t.TempDir(), t.TempDir()
+	if err = ioutil.WriteFile(filepath.Join(second, "logo.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var options = Options{
+		Command:   filepath.Join(bin, "pdflatex"),
+		Texinputs: first + ":" + second,
+	}
+
+	found, err := Resolve("logo.png", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != filepath.Join(second, "logo.png") {
+		t.Error("Wrong file resolved:", found)
+	}
+
+	if _, err = Resolve("missing.png", options); err == nil {
+		t.Error("Should fail for a missing file")
+	}
+
+	requireLatex(t, "kpsewhich")
+	options.Command = ""
+	found, err = Resolve("logo.png", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != filepath.Join(second, "logo.png") {
+		t.Error("Wrong file resolved:", found)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result holds details about a render, mostly gathered from the LaTeX log.
+// Set Options.Result to receive one.
+type Result struct {
+	// Runs is the number of times LaTeX was run, including FontRetries.
+	Runs int
+	// FontRetries is how many of the Runs retried one that failed while
+	// generating fonts; see Options.FontRetries.
+	FontRetries int
+	// Pages is the number of pages in the PDF, or 0 if unknown.
+	Pages int
+	// Duration is how long LaTeX took, over all runs.
+	Duration time.Duration
+	// DestWarnings lists problems with PDF link destinations reported by
+	// pdfTeX, usually caused by duplicate or missing labels. These produce
+	// broken links in the PDF.
+	DestWarnings []DestWarning
+	// Citations lists the keys of the bibliography entries that made it into
+	// the document, in the order LaTeX defined them. They are read from the
+	// \bibcite entries in the .aux file, which thebibliography environment
+	// writes, e.g. from a .bbl file generated by BibTeX.
+	Citations []string
+	// BoxWarnings lists the overfull and underfull box warnings, with the
+	// page each occurred on.
+	BoxWarnings []BoxWarning
+	// Usage lists how much of each of TeX's capacities the last run used.
+	Usage []Usage
+	// Images lists the images the document included, in the order LaTeX
+	// read them. It is only filled in with Options.RecordImages.
+	Images []Image
+	// Lint lists the findings of Options.Lint, one per line of its output.
+	Lint []string
+	// UndefinedReferences and UndefinedCitations list the labels of \ref
+	// and similar commands, and the keys of \cite commands, that LaTeX
+	// warned were undefined in its last run, without duplicates. These show
+	// up as "??" or "[?]" in the PDF.
+	UndefinedReferences []string
+	UndefinedCitations  []string
+}
+
+// BoxWarning is an overfull or underfull box warning, such as "Overfull
+// \hbox (15.0pt too wide) in paragraph at lines 12--14".
+type BoxWarning struct {
+	// Message is the warning text.
+	Message string
+	// Page is the physical page the box is on, counting from 1 regardless of
+	// how the pages are numbered. It's determined from the number of pages
+	// LaTeX had shipped out when it issued the warning, so a box near a page
+	// break may be attributed to the page before or after.
+	Page int
+}
+
+// boxWarning matches overfull and underfull box warnings.
+var boxWarning = regexp.MustCompile(`(?:Over|Under)full \\[hv]box \(.*`)
+
+// pageMarker matches the "[12" markers TeX prints when it ships out a page,
+// capturing the page number as printed.
+var pageMarker = regexp.MustCompile(`\[(\d+)(?:[\]{ ]|$)`)
+
+// undefinedWarning matches LaTeX's and natbib's warnings about undefined
+// references and citations, such as "LaTeX Warning: Reference `fig:plot' on
+// page 1 undefined on input line 12.", capturing the kind and the name.
+var undefinedWarning = regexp.MustCompile(
+	"(Reference|Citation) [`']([^']*)' on page \\d+ undefined")
+
+// DestWarning is a pdfTeX warning about a link destination, such as
+// "destination with the same identifier (name{page.1}) has been already
+// used, duplicate ignored" or "name{fig:plot} has been referenced but does
+// not exist, replaced by a fixed one".
+type DestWarning struct {
+	// Name is the destination name, such as "page.1" or "fig:plot".
+	Name string
+	// Message is the full warning text.
+	Message string
+}
+
+// destWarning matches pdfTeX's warnings about link destinations.
+var destWarning = regexp.MustCompile(`^pdfTeX warning \((?:dest|ext4)\): (.*)$`)
+
+// destName extracts the destination name from a DestWarning message.
+var destName = regexp.MustCompile(`name\{([^}]*)\}`)
+
+// outputWritten matches the line reporting the size of the PDF.
+var outputWritten = regexp.MustCompile(`^Output written on .* \((\d+) pages?, \d+ bytes\)\.`)
+
+// fillResult populates options.Result, if set, after a render in dir that
+// started at start and ran LaTeX runs times, retries of them included.
+func fillResult(dir string, runs, retries int, start time.Time, options Options) {
+	var result = options.Result
+	if result == nil {
+		return
+	}
+	*result = Result{Runs: runs, FontRetries: retries, Duration: time.Since(start)}
+	result.Citations = getCitationsFromAux(dir)
+	if options.RecordImages {
+		result.Images = getImagesFromRecorder(dir)
+	}
+	result.Lint = getLintFindings(dir)
+	var file, err = openLog(path.Join(dir, "gotex.log"), options.LogTail)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	parseLogResult(file, result)
+}
+
+// bibcite matches the \bibcite lines in an .aux file, capturing the key.
+var bibcite = regexp.MustCompile(`^\\bibcite\{([^}]*)\}`)
+
+// getCitationsFromAux returns the keys of the bibliography entries recorded
+// in the .aux file in dir.
+func getCitationsFromAux(dir string) []string {
+	var data, err = ioutil.ReadFile(path.Join(dir, "gotex.aux"))
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := bibcite.FindStringSubmatch(line); m != nil && !contains(keys, m[1]) {
+			keys = append(keys, m[1])
+		}
+	}
+	return keys
+}
+
+// parseLogResult fills in the fields of result that come from the log.
+func parseLogResult(r io.Reader, result *Result) {
+	// The number of pages shipped out so far. This counts the shipout
+	// markers rather than reading their numbers, which restart, e.g. after
+	// roman numbered front matter.
+	var shipped int
+	var ship = func(text string) {
+		shipped += len(pageMarker.FindAllStringIndex(text, -1))
+	}
+	// Whether the line is part of the box contents TeX prints after a box
+	// warning, up to the next empty line. They may contain text like "[12"
+	// that isn't a shipout marker.
+	var inBox bool
+	for _, line := range logLines(r) {
+		if m := boxWarning.FindStringIndex(line); m != nil {
+			// The warning is about the page being built, after any pages
+			// shipped out earlier on the line.
+			ship(line[:m[0]])
+			result.BoxWarnings = append(result.BoxWarnings,
+				BoxWarning{Message: line[m[0]:], Page: shipped + 1})
+			inBox = true
+			continue
+		}
+		if inBox {
+			inBox = line != ""
+			continue
+		}
+		ship(line)
+		if u := parseUsage(line); u != nil {
+			result.Usage = append(result.Usage, u...)
+		} else if m := outputWritten.FindStringSubmatch(line); m != nil {
+			result.Pages, _ = strconv.Atoi(m[1])
+		} else if m := undefinedWarning.FindStringSubmatch(line); m != nil {
+			var list = &result.UndefinedReferences
+			if m[1] == "Citation" {
+				list = &result.UndefinedCitations
+			}
+			if !contains(*list, m[2]) {
+				*list = append(*list, m[2])
+			}
+		} else if m := destWarning.FindStringSubmatch(line); m != nil {
+			var w = DestWarning{Message: m[1]}
+			if n := destName.FindStringSubmatch(m[1]); n != nil {
+				w.Name = n[1]
+			}
+			result.DestWarnings = append(result.DestWarnings, w)
+		}
+	}
+}
+
+// maxPrintLine is the length at which TeX wraps lines in its log.
+const maxPrintLine = 79
+
+// logLines returns the lines of a LaTeX log, rejoining the lines that TeX
+// wrapped because they were too long.
+func logLines(r io.Reader) []string {
+	var lines []string
+	var long strings.Builder
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var line = scanner.Text()
+		long.WriteString(line)
+		if len(line) == maxPrintLine {
+			continue
+		}
+		lines = append(lines, long.String())
+		long.Reset()
+	}
+	if long.Len() > 0 {
+		lines = append(lines, long.String())
+	}
+	return lines
+}
+
+// afterRender prepares for calling options.AfterRender, making sure a Result
+// is collected for it. It returns a function to call with the render's final
+// error.
+func afterRender(options *Options) func(error) {
+	if options.AfterRender == nil {
+		return func(error) {}
+	}
+	if options.Result == nil {
+		options.Result = &Result{}
+	}
+	var hook, result = options.AfterRender, options.Result
+	return func(err error) {
+		hook(*result, err)
+	}
+}
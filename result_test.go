@@ -0,0 +1,265 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// destLog is part of the log of a document with a duplicate and a missing
+// label, wrapped at 79 characters like pdfTeX does.
+const destLog = `[1{/usr/local/texlive/2017/texmf-var/fonts/map/pdftex/updmap/pdftex.map}]
+pdfTeX warning (ext4): destination with the same identifier (name{section.1}) h
+as been already used, duplicate ignored
+<to be read again> 
+                   \relax 
+l.12 \section{Two}
+                  
+pdfTeX warning (dest): name{fig:missing} has been referenced but does not exist
+, replaced by a fixed one
+
+`
+
+func TestDestWarnings(t *testing.T) {
+	var result Result
+	parseLogResult(strings.NewReader(destLog), &result)
+	var want = []DestWarning{
+		{
+			Name: "section.1",
+			Message: "destination with the same identifier (name{section.1}) " +
+				"has been already used, duplicate ignored",
+		},
+		{
+			Name: "fig:missing",
+			Message: "name{fig:missing} has been referenced but does not " +
+				"exist, replaced by a fixed one",
+		},
+	}
+	if !reflect.DeepEqual(result.DestWarnings, want) {
+		t.Errorf("Wrong warnings: %+v", result.DestWarnings)
+	}
+}
+
+func TestResultRuns(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "Rerun to get cross-references right." > gotex.log
+if [ -f done ]; then echo > gotex.log; fi
+touch done
+echo "%PDF-1.5" > gotex.pdf`)
+	var result Result
+	if _, err := Render("doc", Options{Command: command, Result: &result}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Runs != 2 {
+		t.Error("Expected 2 runs, got", result.Runs)
+	}
+}
+
+func TestAfterRender(t *testing.T) {
+	var command, _ = fakeLatex(t, `if [ "$(cat)" = bad ]; then
+	echo "! Undefined control sequence." > gotex.log
+	exit 1
+fi
+echo "Output written on gotex.pdf (3 pages, 9095 bytes)." > gotex.log
+echo "%PDF-1.5" > gotex.pdf`)
+
+	var calls int
+	var result Result
+	var renderErr error
+	var options = Options{
+		Command: command,
+		AfterRender: func(r Result, err error) {
+			calls++
+			result, renderErr = r, err
+		},
+	}
+
+	var err = RenderToFile("good", filepath.Join(t.TempDir(), "out.pdf"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || renderErr != nil {
+		t.Error("Hook should be called once without error", calls, renderErr)
+	}
+	if result.Runs != 1 || result.Pages != 3 || result.Duration <= 0 {
+		t.Errorf("Wrong result: %+v", result)
+	}
+
+	calls = 0
+	_, err = Render("bad", options)
+	if err == nil {
+		t.Fatal("Render should fail")
+	}
+	if calls != 1 || renderErr != err {
+		t.Error("Hook should be called once with the error", calls, renderErr)
+	}
+	if result.Runs != 1 || result.Pages != 0 {
+		t.Errorf("Wrong result: %+v", result)
+	}
+}
+
+func TestCitations(t *testing.T) {
+	var command, _ = fakeLatex(t, `cat > gotex.aux <<'EOF'
+\relax
+\citation{knuth84}
+\citation{lamport94}
+\bibcite{knuth84}{1}
+\bibcite{lamport94}{{2}{1994}{{Lamport}}{{}}}
+\gdef \@abspage@last{1}
+EOF
+echo "%PDF-1.5" > gotex.pdf`)
+	var result Result
+	if _, err := Render("doc", Options{Command: command, Result: &result}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Citations, []string{"knuth84", "lamport94"}) {
+		t.Error("Wrong citations", result.Citations)
+	}
+
+	requireLatex(t, "pdflatex")
+	var _, err = Render(`\documentclass{article}
+\begin{document}
+See \cite{knuth84} and \cite{lamport94}.
+\begin{thebibliography}{9}
+\bibitem{knuth84} D. Knuth. \emph{The \TeX book}. 1984.
+\bibitem{lamport94} L. Lamport. \emph{\LaTeX}. 1994.
+\end{thebibliography}
+\end{document}
+`, Options{Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Citations, []string{"knuth84", "lamport94"}) {
+		t.Error("Wrong citations", result.Citations)
+	}
+}
+
+// boxLog is part of the log of a two-page document with box warnings on
+// both pages.
+const boxLog = `Overfull \hbox (15.0pt too wide) in paragraph at lines 5--6
+[]\OT1/cmr/m/n/10 Supercalifragilisticexpialidocious
+
+Underfull \vbox (badness 10000) has occurred while \output is active []
+
+ [1{/usr/local/texlive/2017/texmf-var/fonts/map/pdftex/updmap/pdftex.map}]
+Overfull \hbox (3.2pt too wide) in paragraph at lines 9--10
+[]\OT1/cmr/m/n/10 Antidisestablishmentarianism
+
+[2] (./gotex.aux) )
+Output written on gotex.pdf (2 pages, 20154 bytes).
+`
+
+// restartLog is part of the log of a document with two roman numbered pages
+// followed by arabic numbered ones, with box warnings on the fifth and sixth
+// pages.
+const restartLog = `[1{/usr/local/texlive/2017/texmf-var/fonts/map/pdftex/updmap/pdftex.map}]
+[2] [1] [2]
+Overfull \hbox (2.0pt too wide) in paragraph at lines 20--21
+[]\OT1/cmr/m/n/10 As shown in [12] and [3 
+[]\OT1/cmr/m/n/10 more]
+
+[3]
+Overfull \hbox (1.0pt too wide) in paragraph at lines 30--31
+[]\OT1/cmr/m/n/10 End
+
+[4] (./gotex.aux) )
+Output written on gotex.pdf (6 pages, 30154 bytes).
+`
+
+func TestBoxWarnings(t *testing.T) {
+	var result Result
+	parseLogResult(strings.NewReader(boxLog), &result)
+	var want = []BoxWarning{
+		{"Overfull \\hbox (15.0pt too wide) in paragraph at lines 5--6", 1},
+		{"Underfull \\vbox (badness 10000) has occurred while \\output is active []", 1},
+		{"Overfull \\hbox (3.2pt too wide) in paragraph at lines 9--10", 2},
+	}
+	if !reflect.DeepEqual(result.BoxWarnings, want) {
+		t.Errorf("Wrong box warnings: %+v", result.BoxWarnings)
+	}
+	if result.Pages != 2 {
+		t.Error("Expected 2 pages, got", result.Pages)
+	}
+
+	// Page numbers restart after the roman numbered front matter, and box
+	// contents may look like page markers.
+	result = Result{}
+	parseLogResult(strings.NewReader(restartLog), &result)
+	want = []BoxWarning{
+		{"Overfull \\hbox (2.0pt too wide) in paragraph at lines 20--21", 5},
+		{"Overfull \\hbox (1.0pt too wide) in paragraph at lines 30--31", 6},
+	}
+	if !reflect.DeepEqual(result.BoxWarnings, want) {
+		t.Errorf("Wrong box warnings: %+v", result.BoxWarnings)
+	}
+
+	requireLatex(t, "pdflatex")
+	var _, err = Render(`\documentclass{article}
+\begin{document}
+\hbox to 1cm{Supercalifragilisticexpialidocious}
+\newpage
+\hbox to 1cm{Antidisestablishmentarianism}
+\end{document}
+`, Options{Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BoxWarnings) != 2 ||
+		result.BoxWarnings[0].Page != 1 || result.BoxWarnings[1].Page != 2 {
+		t.Errorf("Wrong box warnings: %+v", result.BoxWarnings)
+	}
+}
+
+// undefinedLog is part of the log of a document with undefined references
+// and citations, wrapped at 79 characters like LaTeX does.
+const undefinedLog = `
+LaTeX Warning: Reference ` + "`" + `missing' on page 1 undefined on input line 5.
+
+
+LaTeX Warning: Citation ` + "`" + `knuth84' on page 1 undefined on input line 6.
+
+
+LaTeX Warning: Reference ` + "`" + `missing' on page 1 undefined on input line 7.
+
+
+Package natbib Warning: Citation ` + "`" + `lamport94' on page 1 undefined on input
+ line 8.
+
+
+LaTeX Warning: Reference 'sec:a-very-long-label-name-that-wraps' on page 12 und
+efined on input line 9.
+
+LaTeX Warning: There were undefined references.
+`
+
+func TestUndefinedReferences(t *testing.T) {
+	var result Result
+	parseLogResult(strings.NewReader(undefinedLog), &result)
+	var refs = []string{"missing", "sec:a-very-long-label-name-that-wraps"}
+	if !reflect.DeepEqual(result.UndefinedReferences, refs) {
+		t.Errorf("Wrong references: %q", result.UndefinedReferences)
+	}
+	var cites = []string{"knuth84", "lamport94"}
+	if !reflect.DeepEqual(result.UndefinedCitations, cites) {
+		t.Errorf("Wrong citations: %q", result.UndefinedCitations)
+	}
+
+	requireLatex(t, "pdflatex")
+	var _, err = Render(`\documentclass{article}
+\begin{document}
+See \ref{missing} and \cite{knuth84}.
+\end{document}
+`, Options{Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.UndefinedReferences, []string{"missing"}) {
+		t.Errorf("Wrong references: %q", result.UndefinedReferences)
+	}
+	if !reflect.DeepEqual(result.UndefinedCitations, []string{"knuth84"}) {
+		t.Errorf("Wrong citations: %q", result.UndefinedCitations)
+	}
+}
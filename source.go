@@ -0,0 +1,250 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// prepareDocument applies every option that modifies the document source and
+// returns exactly what will be fed to LaTeX.
+func prepareDocument(document string, options Options) string {
+	if options.NormalizeLineEndings {
+		document = normalizeLineEndings(document)
+	}
+	if options.BaseDir != "" {
+		document = resolveSubfile(document, options.BaseDir)
+	}
+	if options.EnsureUTF8 {
+		document = ensureUTF8(document, options)
+	}
+	if options.Preamble != "" {
+		document = injectPreamble(document, options.Preamble)
+	}
+	if options.Geometry != "" {
+		document = injectPreamble(document, geometrySetup(document, options.Geometry))
+	}
+	// hyperref writes its own /Creator, so when it is loaded, the Creator
+	// must be set through it to avoid a second, conflicting entry.
+	var viewer = options.StartView != "" || options.PageLayout != ""
+	var hyperref = viewer || contains(Analyze(document).Packages, "hyperref")
+	if options.Creator != "" && !hyperref {
+		document = injectPreamble(document, docInfo("Creator", options.Creator))
+	}
+	if viewer || (options.Creator != "" && hyperref) {
+		document = injectPreamble(document, hyperrefSetup(document, options))
+	}
+	if options.Tagged && !strings.Contains(document, `\DocumentMetadata`) {
+		// This must come before \documentclass.
+		document = `\DocumentMetadata{testphase=phase-III}` + "\n" + document
+	}
+	if options.Trace {
+		document = injectPreamble(document, `\tracingmacros=2 \tracingcommands=2`)
+	}
+	if options.SourceHeader != "" {
+		document = commentBlock(options.SourceHeader) + document
+	}
+	return document
+}
+
+// commentBlock turns text into TeX comment lines, adding a % to each line
+// that doesn't already start with one.
+func commentBlock(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "%") {
+			line = "% " + line
+		}
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// injectPreamble inserts text on its own line just before \begin{document}.
+// If the document has no \begin{document}, the text is put at the very top.
+func injectPreamble(document, text string) string {
+	var i = strings.Index(document, `\begin{document}`)
+	if i < 0 {
+		return text + "\n" + document
+	}
+	return document[:i] + text + "\n" + document[i:]
+}
+
+// injectAfterClass inserts text on its own line just after the
+// \documentclass line, where packages that others depend on belong. If the
+// document has no \documentclass, it falls back to injectPreamble.
+func injectAfterClass(document, text string) string {
+	var m = documentClass.FindStringIndex(document)
+	if m == nil {
+		return injectPreamble(document, text)
+	}
+	return document[:m[1]] + "\n" + text + document[m[1]:]
+}
+
+// documentClass matches a \documentclass command and its arguments.
+var documentClass = regexp.MustCompile(`\\documentclass\s*(?:\[[^\]]*\])?\s*\{[^}]*\}`)
+
+// ensureUTF8 loads inputenc and fontenc for UTF-8 input, unless the engine
+// supports UTF-8 natively or the document already loads them.
+func ensureUTF8(document string, options Options) string {
+	var e = engine(options)
+	if e == "xelatex" || e == "lualatex" {
+		return document
+	}
+	var packages = Analyze(document).Packages
+	var lines []string
+	if !contains(packages, "inputenc") {
+		lines = append(lines, `\usepackage[utf8]{inputenc}`)
+	}
+	if !contains(packages, "fontenc") {
+		lines = append(lines, `\usepackage[T1]{fontenc}`)
+	}
+	if lines == nil {
+		return document
+	}
+	return injectAfterClass(document, strings.Join(lines, "\n"))
+}
+
+// subfile matches the class line of a document written for the subfiles
+// package, like \documentclass[../main.tex]{subfiles}. The match captures the
+// path to the main document.
+var subfile = regexp.MustCompile(`\\documentclass\[([^\]]+)\]\{subfiles\}`)
+
+// resolveSubfile makes the path to the main document of a subfile absolute by
+// resolving it against baseDir. LaTeX runs in a temporary directory, so a
+// relative path would not be found.
+func resolveSubfile(document, baseDir string) string {
+	var m = subfile.FindStringSubmatchIndex(document)
+	if m == nil {
+		return document
+	}
+	var main = strings.TrimSpace(document[m[2]:m[3]])
+	if !filepath.IsAbs(main) {
+		var abs, err = filepath.Abs(filepath.Join(baseDir, main))
+		if err != nil {
+			return document
+		}
+		main = abs
+	}
+	return document[:m[2]] + main + document[m[3]:]
+}
+
+// subfileMainDir returns the directory of the main document if document is a
+// subfile whose main document path is absolute, or "" otherwise.
+func subfileMainDir(document string) string {
+	var m = subfile.FindStringSubmatch(document)
+	if m == nil || !filepath.IsAbs(m[1]) {
+		return ""
+	}
+	return filepath.Dir(m[1])
+}
+
+// docInfo returns LaTeX that sets a field of the PDF document information
+// dictionary, using whichever mechanism the engine provides: \pdfinfo for
+// pdfTeX, \pdfextension for LuaTeX, and a special for XeTeX.
+func docInfo(key, value string) string {
+	var entry = "/" + key + " " + pdfTextString(value)
+	return `\ifdefined\pdfinfo\pdfinfo{` + entry + `}` +
+		`\else\ifdefined\pdfextension\pdfextension info{` + entry + `}` +
+		`\else\special{pdf:docinfo<<` + entry + `>>}\fi\fi`
+}
+
+// pdfTextString encodes s as a PDF text string in UTF-16BE hex form, like
+// <FEFF0041>. Being pure hex digits, it needs no escaping for TeX or PDF.
+func pdfTextString(s string) string {
+	var b = []byte("<FEFF")
+	for _, r := range s {
+		if r > 0xFFFF {
+			// Encode as a surrogate pair.
+			r -= 0x10000
+			b = append(b, fmt.Sprintf("%04X%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF))...)
+		} else {
+			b = append(b, fmt.Sprintf("%04X", r)...)
+		}
+	}
+	return string(append(b, '>'))
+}
+
+// verbatimBegin matches the start of an environment whose contents must be
+// preserved exactly, capturing the environment name.
+var verbatimBegin = regexp.MustCompile(`\\begin\{(verbatim\*?|Verbatim\*?|lstlisting|minted)\}`)
+
+// normalizeLineEndings replaces CRLF with LF everywhere except inside
+// verbatim-like environments.
+func normalizeLineEndings(document string) string {
+	var b strings.Builder
+	for {
+		var m = verbatimBegin.FindStringSubmatchIndex(document)
+		if m == nil {
+			break
+		}
+		var end = `\end{` + document[m[2]:m[3]] + `}`
+		var e = strings.Index(document[m[1]:], end)
+		if e < 0 {
+			break
+		}
+		e += m[1] + len(end)
+		b.WriteString(strings.Replace(document[:m[0]], "\r\n", "\n", -1))
+		b.WriteString(document[m[0]:e])
+		document = document[e:]
+	}
+	b.WriteString(strings.Replace(document, "\r\n", "\n", -1))
+	return b.String()
+}
+
+// startViews and pageLayouts are the values hyperref accepts for
+// Options.StartView and Options.PageLayout.
+var (
+	startViews  = []string{"Fit", "FitH", "FitV", "FitB", "FitBH", "FitBV"}
+	pageLayouts = []string{"SinglePage", "OneColumn", "TwoColumnLeft",
+		"TwoColumnRight", "TwoPageLeft", "TwoPageRight"}
+)
+
+// checkViewer rejects invalid StartView and PageLayout options, which would
+// otherwise end up in the document source.
+func checkViewer(options Options) error {
+	if options.StartView != "" && !contains(startViews, options.StartView) {
+		return fmt.Errorf("gotex: invalid StartView %q", options.StartView)
+	}
+	if options.PageLayout != "" && !contains(pageLayouts, options.PageLayout) {
+		return fmt.Errorf("gotex: invalid PageLayout %q", options.PageLayout)
+	}
+	return nil
+}
+
+// geometrySetup returns the preamble lines that apply the Geometry option,
+// loading the geometry package unless the document already does. Otherwise,
+// the settings are applied with \geometry, since loading the package twice
+// with different options is an error.
+func geometrySetup(document, settings string) string {
+	if contains(Analyze(document).Packages, "geometry") {
+		return `\geometry{` + settings + `}`
+	}
+	return `\usepackage[` + settings + `]{geometry}`
+}
+
+// hyperrefSetup returns the preamble lines that apply the StartView,
+// PageLayout, and Creator options with hyperref, loading it if document
+// doesn't.
+func hyperrefSetup(document string, options Options) string {
+	var settings []string
+	if options.Creator != "" {
+		settings = append(settings, "pdfcreator={"+EscapeLatex(options.Creator)+"}")
+	}
+	if options.StartView != "" {
+		settings = append(settings, "pdfstartview="+options.StartView)
+	}
+	if options.PageLayout != "" {
+		settings = append(settings, "pdfpagelayout="+options.PageLayout)
+	}
+	var setup = `\hypersetup{` + strings.Join(settings, ",") + `}`
+	if !contains(Analyze(document).Packages, "hyperref") {
+		setup = `\usepackage{hyperref}` + "\n" + setup
+	}
+	return setup
+}
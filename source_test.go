@@ -0,0 +1,477 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	var document = `\documentclass{article}
+\begin{document}
+Hello.
+\end{document}
+`
+	var source bytes.Buffer
+	var _, err = Render(document, Options{
+		Command:  command,
+		Preamble: `\usepackage{graphicx}`,
+		Source:   &source,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = `\documentclass{article}
+\usepackage{graphicx}
+\begin{document}
+Hello.
+\end{document}
+`
+	if source.String() != want {
+		t.Errorf("Wrong captured source:\n%s", source.String())
+	}
+	var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+	if string(fed) != want {
+		t.Errorf("Captured source differs from what LaTeX read:\n%s", fed)
+	}
+}
+
+func TestSubfileResolution(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex
+echo "$TEXINPUTS" > $STATE/texinputs
+echo "%PDF-1.5" > gotex.pdf
+`)
+	var project = t.TempDir()
+	var chapters = filepath.Join(project, "chapters")
+	var _, err = Render(`\documentclass[../main.tex]{subfiles}
+\begin{document}
+Chapter one.
+\end{document}
+`, Options{Command: command, BaseDir: chapters})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+	var main = filepath.Join(project, "main.tex")
+	if !strings.HasPrefix(string(fed), `\documentclass[`+main+`]{subfiles}`) {
+		t.Errorf("Main document path not resolved:\n%s", fed)
+	}
+	var texinputs, _ = ioutil.ReadFile(filepath.Join(state, "texinputs"))
+	if string(texinputs) != chapters+":"+project+":\n" {
+		t.Errorf("Wrong TEXINPUTS: %s", texinputs)
+	}
+}
+
+func TestRelativeBaseDir(t *testing.T) {
+	var command, state = fakeLatex(t, `echo "$TEXINPUTS" > $STATE/texinputs
+echo "%PDF-1.5" > gotex.pdf`)
+	var project = t.TempDir()
+	if err := os.Mkdir(filepath.Join(project, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var wd, err = os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if _, err = Render("doc", Options{Command: command, BaseDir: "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	var texinputs, _ = ioutil.ReadFile(filepath.Join(state, "texinputs"))
+	var docs, _ = filepath.Abs("docs")
+	if string(texinputs) != docs+":\n" {
+		t.Errorf("Wrong TEXINPUTS: %s", texinputs)
+	}
+}
+
+func TestSubfile(t *testing.T) {
+	requireLatex(t, "pdflatex")
+	var project = t.TempDir()
+	var chapters = filepath.Join(project, "chapters")
+	if err := os.Mkdir(chapters, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var main = `\documentclass{article}
+\usepackage{subfiles}
+\newcommand{\project}{Project}
+\begin{document}
+\subfile{chapters/one}
+\end{document}
+`
+	var chapter = `\documentclass[../main.tex]{subfiles}
+\begin{document}
+Chapter one of \project.
+\end{document}
+`
+	if err := ioutil.WriteFile(filepath.Join(project, "main.tex"), []byte(main), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chapters, "one.tex"), []byte(chapter), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var pdf, err = Render(chapter, Options{BaseDir: chapters})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdf) < 1000 {
+		t.Error("Generated PDF is too short", len(pdf))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	// This engine echoes its input into the log.
+	var command, _ = fakeLatex(t, `cat > gotex.log; echo "%PDF-1.5" > gotex.pdf`)
+	var log bytes.Buffer
+	var _, err = Render("\\begin{document}\n", Options{
+		Command: command,
+		Trace:   true,
+		Log:     &log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), `\tracingmacros=2 \tracingcommands=2`) {
+		t.Errorf("Tracing not enabled:\n%s", log.String())
+	}
+}
+
+func TestTraceVerbosity(t *testing.T) {
+	requireLatex(t, "pdflatex")
+	var document = `\documentclass{article}
+\newcommand{\hello}[1]{Hello, #1.}
+\begin{document}
+\hello{world}
+\end{document}
+`
+	var plain, traced bytes.Buffer
+	if _, err := Render(document, Options{Log: &plain}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Render(document, Options{Trace: true, Log: &traced}); err != nil {
+		t.Fatal(err)
+	}
+	if traced.Len() <= plain.Len() {
+		t.Error("Tracing should make the log longer", traced.Len(), plain.Len())
+	}
+	if !strings.Contains(traced.String(), `\hello #1->Hello, #1.`) {
+		t.Error("Trace should show the expansion of \\hello")
+	}
+}
+
+func TestPDFTextString(t *testing.T) {
+	if got := pdfTextString(`v1 (\)`); got != "<FEFF0076003100200028005C0029>" {
+		t.Error("Wrong encoding", got)
+	}
+	if got := pdfTextString("\U0001F600"); got != "<FEFFD83DDE00>" {
+		t.Error("Wrong surrogate pair encoding", got)
+	}
+}
+
+func TestCreator(t *testing.T) {
+	var version = `report-gen 2.1.0 (rev #42 & 50% \done)`
+	var command, _ = fakeLatex(t, `cat > /dev/null; echo "%PDF-1.5" > gotex.pdf`)
+	var source bytes.Buffer
+	var _, err = Render("\\begin{document}\n", Options{
+		Command: command,
+		Creator: version,
+		Source:  &source,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source.String(), "/Creator "+pdfTextString(version)) {
+		t.Errorf("Creator not injected:\n%s", source.String())
+	}
+
+	requireLatex(t, "pdflatex")
+	pdf, err := Render(`\documentclass{article}
+\begin{document}
+Report.
+\end{document}
+`, Options{Creator: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(pdf, []byte(pdfTextString(version))) {
+		t.Error("Creator not found in PDF")
+	}
+}
+
+func TestCreatorWithHyperref(t *testing.T) {
+	var command, _ = fakeLatex(t, `cat > /dev/null; echo "%PDF-1.5" > gotex.pdf`)
+	var document = "\\documentclass{article}\n\\begin{document}\n\\end{document}\n"
+	var withHyperref = strings.Replace(document, "\\begin{document}",
+		"\\usepackage{hyperref}\n\\begin{document}", 1)
+	var tests = []struct {
+		document string
+		options  Options
+	}{
+		{document, Options{StartView: "FitH"}},
+		{withHyperref, Options{}},
+	}
+	for _, test := range tests {
+		var source bytes.Buffer
+		test.options.Command = command
+		test.options.Creator = "report-gen 2.1.0 (rev #42)"
+		test.options.Source = &source
+		if _, err := Render(test.document, test.options); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(source.String(), "/Creator") ||
+			!strings.Contains(source.String(), `pdfcreator={report-gen 2.1.0 (rev \#42)}`) {
+			t.Errorf("Creator should be set through hyperref:\n%s", source.String())
+		}
+		if strings.Count(source.String(), "usepackage{hyperref}") != 1 {
+			t.Errorf("hyperref should be loaded once:\n%s", source.String())
+		}
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	var fed = func(document string) string {
+		var _, err = Render(document, Options{
+			Command:              command,
+			NormalizeLineEndings: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+		return string(data)
+	}
+	var lf = "\\documentclass{article}\n\\begin{document}\nHello.\n\\end{document}\n"
+	var crlf = strings.Replace(lf, "\n", "\r\n", -1)
+	if fed(crlf) != fed(lf) {
+		t.Error("CRLF document should compile like the LF one")
+	}
+
+	var verbatim = "a\r\n\\begin{lstlisting}\r\nx\r\n\\end{lstlisting}\r\nb\r\n"
+	var want = "a\n\\begin{lstlisting}\r\nx\r\n\\end{lstlisting}\nb\n"
+	if got := normalizeLineEndings(verbatim); got != want {
+		t.Errorf("Verbatim contents should be preserved: %q", got)
+	}
+}
+
+func TestViewerOptions(t *testing.T) {
+	var command, _ = fakeLatex(t, `cat > /dev/null; echo "%PDF-1.5" > gotex.pdf`)
+	var document = `\documentclass{article}
+\begin{document}
+Two pages.
+\end{document}
+`
+	var source bytes.Buffer
+	var options = Options{
+		Command:    command,
+		StartView:  "FitH",
+		PageLayout: "TwoPageRight",
+		Source:     &source,
+	}
+	if _, err := Render(document, options); err != nil {
+		t.Fatal(err)
+	}
+	var want = "\\usepackage{hyperref}\n" +
+		"\\hypersetup{pdfstartview=FitH,pdfpagelayout=TwoPageRight}\n" +
+		"\\begin{document}"
+	if !strings.Contains(source.String(), want) {
+		t.Errorf("Viewer settings not injected:\n%s", source.String())
+	}
+
+	// hyperref isn't loaded twice.
+	source.Reset()
+	var withHyperref = strings.Replace(document, "\\begin{document}",
+		"\\usepackage[colorlinks]{hyperref}\n\\begin{document}", 1)
+	if _, err := Render(withHyperref, options); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(source.String(), "hyperref") != 1 {
+		t.Errorf("hyperref loaded twice:\n%s", source.String())
+	}
+
+	options.PageLayout = "TwoPageRight}\\input{/etc/passwd}"
+	if _, err := Render(document, options); err == nil {
+		t.Error("Should reject invalid PageLayout")
+	}
+
+	requireLatex(t, "pdflatex")
+	options.Command = ""
+	options.PageLayout = "TwoPageRight"
+	pdf, err := Render(document, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdf) < 1000 {
+		t.Error("Generated PDF is too short", len(pdf))
+	}
+}
+
+func TestEnsureUTF8(t *testing.T) {
+	var command, _ = fakeLatex(t, `cat > /dev/null; echo "%PDF-1.5" > gotex.pdf`)
+	var document = `\documentclass[12pt]{article}
+\usepackage{graphicx}
+\begin{document}
+Crème brûlée, Ångström, naïve façade.
+\end{document}
+`
+	var source bytes.Buffer
+	var _, err = Render(document, Options{
+		Command:    command,
+		EnsureUTF8: true,
+		Source:     &source,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = `\documentclass[12pt]{article}
+\usepackage[utf8]{inputenc}
+\usepackage[T1]{fontenc}
+\usepackage{graphicx}
+`
+	if !strings.HasPrefix(source.String(), want) {
+		t.Errorf("Packages not injected after the class:\n%s", source.String())
+	}
+
+	// Packages already loaded aren't duplicated.
+	source.Reset()
+	var latin1 = strings.Replace(document, "{graphicx}", "[latin1]{inputenc}", 1)
+	_, err = Render(latin1, Options{Command: command, EnsureUTF8: true, Source: &source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(source.String(), "inputenc") != 1 || !strings.Contains(source.String(), "fontenc") {
+		t.Errorf("Wrong packages injected:\n%s", source.String())
+	}
+
+	requireLatex(t, "pdflatex")
+	var pdf []byte
+	pdf, err = Render(document, Options{EnsureUTF8: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdf) < 1000 {
+		t.Error("Generated PDF is too short", len(pdf))
+	}
+}
+
+func TestTagged(t *testing.T) {
+	// Put a fake lualatex first in $PATH, to see that it's selected.
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	if err := os.Rename(command, filepath.Join(state, "lualatex")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", state+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var document = "\\documentclass{article}\n\\begin{document}\nHello.\n\\end{document}\n"
+	if _, err := Render(document, Options{Tagged: true}); err != nil {
+		t.Fatal(err)
+	}
+	var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+	if string(fed) != "\\DocumentMetadata{testphase=phase-III}\n"+document {
+		t.Errorf("Tagging not set up:\n%s", fed)
+	}
+
+	// Existing metadata is left alone.
+	var withMetadata = "\\DocumentMetadata{pdfstandard=ua-1}\n" + document
+	if _, err := Render(withMetadata, Options{Tagged: true}); err != nil {
+		t.Fatal(err)
+	}
+	fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+	if string(fed) != withMetadata {
+		t.Errorf("Metadata should not be duplicated:\n%s", fed)
+	}
+}
+
+func TestTaggedCompile(t *testing.T) {
+	requireLatex(t, "lualatex")
+	var log bytes.Buffer
+	var pdf, err = Render(`\documentclass{article}
+\begin{document}
+\section{Accessible}
+Hello.
+\end{document}
+`, Options{Tagged: true, Log: &log})
+	if err != nil {
+		if strings.Contains(log.String(), "DocumentMetadata") {
+			t.Skip("LaTeX is too old for tagging")
+		}
+		t.Fatal(err)
+	}
+	if len(pdf) < 1000 {
+		t.Error("Generated PDF is too short", len(pdf))
+	}
+}
+
+func TestSourceHeader(t *testing.T) {
+	var command, _ = fakeLatex(t, `echo "%PDF-1.5" > gotex.pdf`)
+	var document = "\\documentclass{article}\n\\begin{document}\nHello.\n\\end{document}\n"
+	var source bytes.Buffer
+	var _, err = Render(document, Options{
+		Command:      command,
+		Source:       &source,
+		SourceHeader: "Generated by invoicer\n\n%% build 42\r\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = "% Generated by invoicer\n%\n%% build 42\n" + document
+	if source.String() != expected {
+		t.Errorf("Wrong source header:\n%s", source.String())
+	}
+
+	requireLatex(t, "pdflatex")
+	plain, err := Render(document, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHeader, err := Render(document, Options{SourceHeader: "Generated by gotex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != len(withHeader) {
+		t.Errorf("Header changed the output, %d bytes instead of %d",
+			len(withHeader), len(plain))
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	var command, state = fakeLatex(t, `cat > $STATE/fed.tex; echo "%PDF-1.5" > gotex.pdf`)
+	var tests = map[string]string{
+		"\\documentclass{article}\n\\begin{document}\n\\end{document}\n": "\\documentclass{article}\n" +
+			"\\usepackage[a5paper,margin=1cm]{geometry}\n\\begin{document}\n\\end{document}\n",
+		"\\documentclass{article}\n\\usepackage[a4paper]{geometry}\n\\begin{document}\n\\end{document}\n": "\\documentclass{article}\n" +
+			"\\usepackage[a4paper]{geometry}\n\\geometry{a5paper,margin=1cm}\n\\begin{document}\n\\end{document}\n",
+	}
+	for document, expected := range tests {
+		if _, err := Render(document, Options{Command: command, Geometry: "a5paper,margin=1cm"}); err != nil {
+			t.Fatal(err)
+		}
+		var fed, _ = ioutil.ReadFile(filepath.Join(state, "fed.tex"))
+		if string(fed) != expected {
+			t.Errorf("Wrong geometry setup:\n%s", fed)
+		}
+	}
+
+	requireLatex(t, "pdflatex")
+	// Leave the page objects uncompressed, so the page size can be read.
+	var document = "\\pdfobjcompresslevel=0\n\\documentclass{article}\n\\begin{document}\nHello.\n\\end{document}\n"
+	pdf, err := Render(document, Options{Geometry: "a5paper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A5 is 148mm by 210mm, or about 420 by 595 points.
+	if !bytes.Contains(pdf, []byte("/MediaBox [0 0 419.5")) {
+		t.Error("Page size should be A5")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrUsageExceeded is returned, wrapped, when a document uses more of TeX's
+// capacity than Options.MaxUsage allows.
+var ErrUsageExceeded = errors.New("TeX capacity usage exceeded")
+
+// Usage is how much of one of TeX's fixed capacities a render used, as
+// reported in the statistics at the end of the log, such as "1883388 words
+// of memory out of 5000000".
+type Usage struct {
+	// Name describes the capacity, such as "strings" or "words of memory".
+	Name string
+	// Used is the amount used.
+	Used int
+	// Limit is the capacity, as configured in texmf.cnf.
+	Limit int
+}
+
+// Fraction returns how much of the capacity was used, from 0 to 1.
+func (u Usage) Fraction() float64 {
+	if u.Limit <= 0 {
+		return 0
+	}
+	return float64(u.Used) / float64(u.Limit)
+}
+
+// usageLine matches a line of the statistics block in the log, such as
+// " 5405 strings out of 478287" or " 558832 words of font info for 37 fonts,
+// out of 8000000 for 9000". Limits given as a sum, like 15000+600000, are
+// captured in two parts.
+var usageLine = regexp.MustCompile(
+	`^ (\d+) ([a-z][a-z ]*?)(?: for \d+ fonts?,)? out of (\d+)(?:\+(\d+))?`)
+
+// stackLine matches the stack positions line of the statistics block, such
+// as " 75i,5n,79p,512b,235s stack positions out of
+// 10000i,1000n,20000p,200000b,200000s".
+var stackLine = regexp.MustCompile(`^ (\S+) stack positions out of (\S+)`)
+
+// stackNames describes the stacks in the stack positions line by their
+// suffix letter.
+var stackNames = map[string]string{
+	"i": "input stack positions",
+	"n": "semantic nest positions",
+	"p": "parameter stack positions",
+	"b": "buffer positions",
+	"s": "save stack positions",
+}
+
+// parseUsage returns the usage reported by a line of the log, if any.
+func parseUsage(line string) []Usage {
+	if m := stackLine.FindStringSubmatch(line); m != nil {
+		var used, limits = strings.Split(m[1], ","), strings.Split(m[2], ",")
+		if len(used) != len(limits) {
+			return nil
+		}
+		var usage []Usage
+		for i := range used {
+			var u = Usage{Name: stackNames[used[i][len(used[i])-1:]]}
+			u.Used, _ = strconv.Atoi(strings.TrimRight(used[i], "inpbs"))
+			u.Limit, _ = strconv.Atoi(strings.TrimRight(limits[i], "inpbs"))
+			if u.Name != "" {
+				usage = append(usage, u)
+			}
+		}
+		return usage
+	}
+	if m := usageLine.FindStringSubmatch(line); m != nil {
+		var u = Usage{Name: m[2]}
+		u.Used, _ = strconv.Atoi(m[1])
+		u.Limit, _ = strconv.Atoi(m[3])
+		if m[4] != "" {
+			var extra, _ = strconv.Atoi(m[4])
+			u.Limit += extra
+		}
+		return []Usage{u}
+	}
+	return nil
+}
+
+// checkUsage compares the usage reported in the log in dir against
+// Options.WarnUsage and Options.MaxUsage.
+func checkUsage(dir string, options Options) error {
+	if options.WarnUsage <= 0 && options.MaxUsage <= 0 {
+		return nil
+	}
+	var file, err = openLog(path.Join(dir, "gotex.log"), options.LogTail)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, line := range logLines(file) {
+		for _, u := range parseUsage(line) {
+			if options.MaxUsage > 0 && u.Fraction() > options.MaxUsage {
+				return fmt.Errorf("gotex: %w: %d %s out of %d",
+					ErrUsageExceeded, u.Used, u.Name, u.Limit)
+			}
+			if options.WarnUsage > 0 && u.Fraction() > options.WarnUsage {
+				logf(options, "gotex: document used %d %s out of %d",
+					u.Used, u.Name, u.Limit)
+			}
+		}
+	}
+	return nil
+}
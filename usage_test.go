@@ -0,0 +1,86 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// usageLog is the end of the log of a document that used nearly all of
+// TeX's main memory.
+const usageLog = ` ) 
+Here is how much of TeX's memory you used:
+ 5405 strings out of 478287
+ 105770 string characters out of 5849395
+ 4700000 words of memory out of 5000000
+ 23531 multiletter control sequences out of 15000+600000
+ 558832 words of font info for 37 fonts, out of 8000000 for 9000
+ 1141 hyphenation exceptions out of 8191
+ 75i,5n,79p,512b,235s stack positions out of 10000i,1000n,20000p,200000b,200000s
+Output written on gotex.pdf (1 page, 12345 bytes).
+`
+
+func TestParseUsage(t *testing.T) {
+	var result Result
+	parseLogResult(strings.NewReader(usageLog), &result)
+	var want = []Usage{
+		{"strings", 5405, 478287},
+		{"string characters", 105770, 5849395},
+		{"words of memory", 4700000, 5000000},
+		{"multiletter control sequences", 23531, 615000},
+		{"words of font info", 558832, 8000000},
+		{"hyphenation exceptions", 1141, 8191},
+		{"input stack positions", 75, 10000},
+		{"semantic nest positions", 5, 1000},
+		{"parameter stack positions", 79, 20000},
+		{"buffer positions", 512, 200000},
+		{"save stack positions", 235, 200000},
+	}
+	if !reflect.DeepEqual(result.Usage, want) {
+		t.Errorf("Wrong usage: %+v", result.Usage)
+	}
+	if result.Pages != 1 {
+		t.Error("Expected 1 page, got", result.Pages)
+	}
+}
+
+func TestMaxUsage(t *testing.T) {
+	var command, state = fakeLatex(t, `cp $STATE/log gotex.log
+echo "%PDF-1.5" > gotex.pdf`)
+	if err := ioutil.WriteFile(filepath.Join(state, "log"), []byte(usageLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tmp = t.TempDir()
+	var _, err = Render("doc", Options{Command: command, MaxUsage: 0.8, TempDir: tmp})
+	if !errors.Is(err, ErrUsageExceeded) {
+		t.Fatal("Expected ErrUsageExceeded, got", err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Error("Temporary directory should be removed, found", len(files))
+	}
+	if !strings.Contains(err.Error(), "4700000 words of memory out of 5000000") {
+		t.Error("Error should name the capacity:", err)
+	}
+
+	if _, err = Render("doc", Options{Command: command, MaxUsage: 0.95}); err != nil {
+		t.Error("Usage under the threshold should pass:", err)
+	}
+
+	var buf bytes.Buffer
+	_, err = Render("doc", Options{Command: command, WarnUsage: 0.8, Logger: log.New(&buf, "", 0)})
+	if err != nil {
+		t.Fatal("WarnUsage should not fail:", err)
+	}
+	if !strings.Contains(buf.String(), "document used 4700000 words of memory") {
+		t.Error("Expected a warning, got", buf.String())
+	}
+}
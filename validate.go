@@ -0,0 +1,35 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// ValidateTemplate executes tmpl with sampleData and checks that the result
+// compiles, so that broken templates can be caught when a service starts
+// rather than when the first request arrives. The check is a single LaTeX
+// run in draft mode, which skips writing the PDF, so it is faster than a
+// full render; PostValidate is not run. Errors from LaTeX are returned as an
+// *Error, wrapped with the template's name.
+func ValidateTemplate(tmpl *template.Template, sampleData interface{}, options Options) (err error) {
+	var done = afterRender(&options)
+	defer func() { done(err) }()
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, sampleData); err != nil {
+		return err
+	}
+	options.Runs = 1
+	options.PostValidate = nil
+	options.draft = true
+	dir, err := compile(buf.String(), options, nil)
+	if err != nil {
+		return fmt.Errorf("gotex: template %s: %w", tmpl.Name(), err)
+	}
+	removeDir(dir, options)
+	return nil
+}
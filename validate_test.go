@@ -0,0 +1,94 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// invoiceTemplate is a template whose output doesn't compile if the
+// customer's name contains an unescaped &.
+var invoiceTemplate = template.Must(template.New("invoice").Parse(`\documentclass{article}
+\begin{document}
+Invoice for {{.Customer}}.
+\end{document}
+`))
+
+func TestValidateTemplate(t *testing.T) {
+	var command, state = fakeLatex(t, `if [ "$1" = --help ]; then echo "-draftmode"; exit; fi
+echo run >> $STATE/runs
+echo "$*" > $STATE/args
+if grep -q "&" -; then
+	echo "! Misplaced alignment tab character &." > gotex.log
+	exit 1
+fi
+echo > gotex.log`)
+	var options = Options{Command: command}
+
+	var err = ValidateTemplate(invoiceTemplate, map[string]string{"Customer": "ACME"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if !strings.Contains(string(args), "-draftmode") {
+		t.Error("Expected a draft mode run, got", string(args))
+	}
+
+	err = ValidateTemplate(invoiceTemplate, map[string]string{"Customer": "Smith & Sons"}, options)
+	var latexErr *Error
+	if !errors.As(err, &latexErr) {
+		t.Fatal("Expected a LaTeX error, got", err)
+	}
+	if len(latexErr.Errors) != 1 || latexErr.Errors[0].Message != "Misplaced alignment tab character &." {
+		t.Errorf("Wrong errors: %+v", latexErr.Errors)
+	}
+	if !strings.Contains(err.Error(), "template invoice") {
+		t.Error("Error should name the template:", err)
+	}
+	if runs := countRuns(t, state); runs != 2 {
+		t.Error("Expected one run per validation, got", runs)
+	}
+
+	// Errors executing the template itself are returned too.
+	if err = ValidateTemplate(invoiceTemplate, 42, options); err == nil {
+		t.Error("Should fail to execute the template")
+	}
+
+	requireLatex(t, "pdflatex")
+	if err = ValidateTemplate(invoiceTemplate, map[string]string{"Customer": "ACME"}, Options{}); err != nil {
+		t.Error(err)
+	}
+	err = ValidateTemplate(invoiceTemplate, map[string]string{"Customer": "Smith & Sons"}, Options{})
+	if !errors.As(err, &latexErr) {
+		t.Error("Expected a LaTeX error, got", err)
+	}
+}
+
+func TestValidateTemplateFallback(t *testing.T) {
+	var pdflatex, _ = fakeLatex(t, `if [ "$1" = --help ]; then echo "-draftmode"; exit; fi
+echo "! Fatal Package fontspec Error: The fontspec package requires either XeTeX or LuaTeX." > gotex.log
+exit 1`)
+	var xelatex, state = fakeLatex(t, `if [ "$1" = --help ]; then echo "-synctex=NUMBER"; exit; fi
+echo "$*" > $STATE/args
+echo > gotex.log`)
+	var options = Options{Command: pdflatex, Fallback: []string{xelatex}, SyncTeX: true}
+
+	var err = ValidateTemplate(invoiceTemplate, map[string]string{"Customer": "ACME"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each engine gets the flags it supports, not those of the one before.
+	var args, _ = ioutil.ReadFile(filepath.Join(state, "args"))
+	if strings.Contains(string(args), "-draftmode") {
+		t.Error("Unsupported flag was passed to the fallback:", string(args))
+	}
+	if !strings.Contains(string(args), "-synctex=1") {
+		t.Error("Supported flag wasn't passed to the fallback:", string(args))
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// Workspace renders documents in a persistent working directory instead of a
+// new temporary directory each time. The auxiliary files LaTeX writes, such
+// as the .aux file, are kept between renders, so when a document is rendered
+// again with stable references, LaTeX finds them up to date and fewer runs
+// are needed. This suits incremental recompiles of one document, e.g. in a
+// live preview.
+//
+// The directory is never removed by gotex. Renders through one Workspace are
+// serialized, but a directory must not be shared by several Workspaces or
+// processes at once, since they would overwrite each other's files.
+type Workspace struct {
+	mu      sync.Mutex
+	options Options
+}
+
+// NewWorkspace returns a Workspace that renders in dir with the given
+// options. The directory is created if it doesn't exist.
+func NewWorkspace(dir string, options Options) (*Workspace, error) {
+	if dir == "" {
+		return nil, errors.New("gotex: workspace directory not given")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := checkTempDir(dir); err != nil {
+		return nil, err
+	}
+	options.workDir = dir
+	return &Workspace{options: options}, nil
+}
+
+// Render renders a document in the workspace, like the package-level Render.
+func (w *Workspace) Render(document string) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return render(document, w.options, nil)
+}
+
+// RenderToFile renders a document in the workspace to outFilename, like the
+// package-level RenderToFile.
+func (w *Workspace) RenderToFile(document string, outFilename string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return renderToFile(document, outFilename, w.options, nil)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2017, Randy Westlund. All rights reserved.
+// This code is under the BSD-2-Clause license.
+
+package gotex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	// This engine asks for a rerun unless the .aux from before is up to date.
+	var command, _ = fakeLatex(t, `cat > /dev/null
+if [ "$(cat gotex.aux 2>/dev/null)" = "labels" ]; then
+	echo > gotex.log
+else
+	echo "Rerun to get cross-references right." > gotex.log
+fi
+echo labels > gotex.aux
+echo "%PDF-1.5" > gotex.pdf`)
+	var dir = filepath.Join(t.TempDir(), "work")
+	var result Result
+	var w, err = NewWorkspace(dir, Options{Command: command, Result: &result})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Render("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if result.Runs != 2 {
+		t.Error("First render should need 2 runs, got", result.Runs)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "gotex.aux")); err != nil {
+		t.Error("Workspace should be kept:", err)
+	}
+
+	if err = w.RenderToFile("doc", filepath.Join(t.TempDir(), "out.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if result.Runs != 1 {
+		t.Error("Second render should reuse the .aux and need 1 run, got", result.Runs)
+	}
+}
+
+func TestWorkspaceNoOutput(t *testing.T) {
+	// This engine writes no PDF for empty documents, like LaTeX does for
+	// "No pages of output.".
+	var command, _ = fakeLatex(t, `echo > gotex.log
+if [ -n "$(cat)" ]; then echo "%PDF-1.5" > gotex.pdf; fi`)
+	var w, err = NewWorkspace(filepath.Join(t.TempDir(), "work"), Options{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Render("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if pdf, err := w.Render(""); err == nil {
+		t.Errorf("Should fail without output, got %q", pdf)
+	}
+	var out = filepath.Join(t.TempDir(), "out.pdf")
+	if err = w.RenderToFile("", out); err == nil {
+		t.Error("Should fail without output")
+	}
+	if _, err = os.Stat(out); err == nil {
+		t.Error("The previous PDF should not be written")
+	}
+}